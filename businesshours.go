@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// businessCalendar defines the working hours used to measure durations in business time
+type businessCalendar struct {
+	// start and end of the working hours in minutes since midnight
+	start, end int
+	days       map[time.Weekday]bool
+	location   *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// newBusinessCalendar parses the working hours like "09:00" and "18:00" and the comma-separated
+// working days like "Mon,Tue,Wed,Thu,Fri"
+func newBusinessCalendar(start, end, days string, location *time.Location) (*businessCalendar, error) {
+	c := &businessCalendar{days: make(map[time.Weekday]bool), location: location}
+	var err error
+	if c.start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if c.end, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if c.end <= c.start {
+		return nil, fmt.Errorf("business hours end %s must be after start %s", end, start)
+	}
+	for _, d := range parseList(days) {
+		weekday, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("invalid business day %q", d)
+		}
+		c.days[weekday] = true
+	}
+	if len(c.days) == 0 {
+		return nil, fmt.Errorf("no business days in %q", days)
+	}
+	return c, nil
+}
+
+// parseClock parses the time of day like "09:30" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// workingDuration returns the part of the interval between from and to that falls into the working hours
+func (c *businessCalendar) workingDuration(from, to time.Time) time.Duration {
+	if !to.After(from) {
+		return 0
+	}
+	from, to = from.In(c.location), to.In(c.location)
+	var total time.Duration
+	year, month, dayOfMonth := from.Date()
+	for dayStart := time.Date(year, month, dayOfMonth, 0, 0, 0, 0, c.location); dayStart.Before(to); dayStart = dayStart.AddDate(0, 0, 1) {
+		if !c.days[dayStart.Weekday()] {
+			continue
+		}
+		// time.Date instead of Add keeps the working hours right on DST switch days
+		y, m, d := dayStart.Date()
+		workStart := time.Date(y, m, d, 0, c.start, 0, 0, c.location)
+		workEnd := time.Date(y, m, d, 0, c.end, 0, 0, c.location)
+		if workStart.Before(from) {
+			workStart = from
+		}
+		if workEnd.After(to) {
+			workEnd = to
+		}
+		if workEnd.After(workStart) {
+			total += workEnd.Sub(workStart)
+		}
+	}
+	return total
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkingDuration(t *testing.T) {
+	calendar, err := newBusinessCalendar("09:00", "18:00", "Mon,Tue,Wed,Thu,Fri", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day int, hour, minute int) time.Time {
+		// January 2024 starts on Monday
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     time.Duration
+	}{
+		{"within the hours", at(1, 10, 0), at(1, 12, 30), 2*time.Hour + 30*time.Minute},
+		{"before the hours", at(1, 6, 0), at(1, 8, 0), 0},
+		{"after the hours", at(1, 19, 0), at(1, 23, 0), 0},
+		{"overlapping the start", at(1, 8, 0), at(1, 10, 0), time.Hour},
+		{"overlapping the end", at(1, 17, 0), at(1, 20, 0), time.Hour},
+		{"whole day", at(1, 0, 0), at(2, 0, 0), 9 * time.Hour},
+		{"overnight", at(1, 17, 0), at(2, 10, 0), 2 * time.Hour},
+		{"over the weekend", at(5, 17, 0), at(8, 10, 0), 2 * time.Hour},
+		{"within the weekend", at(6, 10, 0), at(7, 16, 0), 0},
+		{"from the weekend", at(7, 12, 0), at(8, 12, 0), 3 * time.Hour},
+		{"two weeks", at(1, 0, 0), at(15, 0, 0), 10 * 9 * time.Hour},
+		{"empty", at(1, 10, 0), at(1, 10, 0), 0},
+		{"reversed", at(2, 10, 0), at(1, 10, 0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calendar.workingDuration(tt.from, tt.to); got != tt.want {
+				t.Errorf("workingDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkingDurationTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendar, err := newBusinessCalendar("09:00", "18:00", "Mon,Tue,Wed,Thu,Fri,Sat,Sun", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 09:00-18:00 in Berlin is 08:00-17:00 UTC in winter
+	from := time.Date(2024, time.January, 8, 7, 0, 0, 0, time.UTC)
+	to := time.Date(2024, time.January, 8, 9, 0, 0, 0, time.UTC)
+	if got := calendar.workingDuration(from, to); got != time.Hour {
+		t.Errorf("workingDuration() = %s, want 1h", got)
+	}
+	// The clocks go forward on March 31, 2024 at 02:00, the working hours stay 9 hours long
+	from = time.Date(2024, time.March, 30, 0, 0, 0, 0, berlin)
+	to = time.Date(2024, time.April, 1, 0, 0, 0, 0, berlin)
+	if got := calendar.workingDuration(from, to); got != 18*time.Hour {
+		t.Errorf("workingDuration() over the DST switch = %s, want 18h", got)
+	}
+}
+
+func TestNewBusinessCalendarErrors(t *testing.T) {
+	for _, tt := range []struct{ start, end, days string }{
+		{"9am", "18:00", "Mon"},
+		{"09:00", "25:00", "Mon"},
+		{"18:00", "09:00", "Mon"},
+		{"09:00", "18:00", "Monday"},
+		{"09:00", "18:00", ""},
+	} {
+		if _, err := newBusinessCalendar(tt.start, tt.end, tt.days, time.UTC); err == nil {
+			t.Errorf("newBusinessCalendar(%q, %q, %q) succeeded, want an error", tt.start, tt.end, tt.days)
+		}
+	}
+}
+
+func TestStatusDurationsInBusinessHours(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.businessCalendar, err = newBusinessCalendar("09:00", "18:00", "Mon,Tue,Wed,Thu,Fri", time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	// Created on Friday 16:00, started on Monday 11:00, done on Monday 15:00
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-05T16:00:00.000+0000"},
+		"changelog": {"histories": [
+			{"created": "2024-01-08T15:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress"}]},
+			{"created": "2024-01-08T11:00:00.000+0000", "items": [{"field": "status", "fromString": "Open"}]}]}}`)
+	durations, _ := statusDurations(cfg, issue)
+	if durations["Open"] != 4*time.Hour || durations["In Progress"] != 4*time.Hour {
+		t.Errorf("statusDurations() = %v, want 4h in Open and 4h in In Progress", durations)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// statusCategories maps the status IDs to their categories. The mapping is fetched from Jira once.
+type statusCategories struct {
+	mu   sync.Mutex
+	byID map[string]StatusCategory
+}
+
+// load fetches the mapping unless it's already fetched
+func (c *statusCategories) load(ctx context.Context, cfg config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byID != nil {
+		return nil
+	}
+	var statuses []struct {
+		ID             string         `json:"id"`
+		StatusCategory StatusCategory `json:"statusCategory"`
+	}
+	if err := getJSON(ctx, cfg, cfg.jiraURL+"/rest/api/3/status", &statuses); err != nil {
+		return err
+	}
+	c.byID = make(map[string]StatusCategory, len(statuses))
+	for _, status := range statuses {
+		c.byID[status.ID] = status.StatusCategory
+	}
+	return nil
+}
+
+// category returns the category of the status. The second result is false if the status is unknown
+// or the mapping isn't loaded.
+func (c *statusCategories) category(statusID string) (StatusCategory, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	category, ok := c.byID[statusID]
+	return category, ok
+}
+
+// categoryTransitions returns the status category changes in the issue changelog as [from, to] pairs
+// of the category names. The categories are compared by their keys.
+func categoryTransitions(issue JiraIssue, categories *statusCategories) [][2]string {
+	transitions := make([][2]string, 0)
+	for _, history := range issue.Changelog.Histories {
+		for _, item := range history.Items {
+			if item.Field != "status" {
+				continue
+			}
+			from, fromOK := categories.category(item.From)
+			to, toOK := categories.category(item.To)
+			if fromOK && toOK && from.Key != to.Key {
+				transitions = append(transitions, [2]string{from.Name, to.Name})
+			}
+		}
+	}
+	return transitions
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCategoryTransitions(t *testing.T) {
+	requests := 0
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/rest/api/3/status" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		_, _ = w.Write([]byte(`[
+			{"id": "1", "statusCategory": {"key": "new", "name": "To Do"}},
+			{"id": "2", "statusCategory": {"key": "new", "name": "To Do"}},
+			{"id": "3", "statusCategory": {"key": "indeterminate", "name": "In Progress"}},
+			{"id": "4", "statusCategory": {"key": "done", "name": "Done"}}]`))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+	for i := 0; i < 2; i++ {
+		if err := cfg.statusCategories.load(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("the statuses were fetched %d times, want once", requests)
+	}
+
+	// Open -> Backlog -> In Progress -> Done -> In Progress -> Done, and a status unknown to Jira
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"key": "done", "name": "Done"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-07T10:00:00.000+0000", "items": [{"field": "status", "from": "4", "to": "99"}]},
+			{"created": "2024-01-06T10:00:00.000+0000", "items": [{"field": "status", "from": "3", "to": "4"}]},
+			{"created": "2024-01-05T10:00:00.000+0000", "items": [{"field": "status", "from": "4", "to": "3"}]},
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [{"field": "status", "from": "3", "to": "4"}]},
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "status", "from": "2", "to": "3"}]},
+			{"created": "2024-01-02T10:00:00.000+0000", "items": [
+				{"field": "assignee", "from": "1", "to": "3"},
+				{"field": "status", "from": "1", "to": "2"}]}]}}`))
+
+	transitions := gatherMetrics(t, registry, "jira_issue_category_transitions_total")
+	want := []struct {
+		from, to string
+		count    float64
+	}{
+		{"To Do", "In Progress", 1},
+		{"In Progress", "Done", 2},
+		{"Done", "In Progress", 1},
+	}
+	if len(transitions) != len(want) {
+		t.Errorf("jira_issue_category_transitions_total has %d series, want %d", len(transitions), len(want))
+	}
+	for _, w := range want {
+		metric := findMetric(transitions, map[string]string{"project": "PROJ", "from_category": w.from, "to_category": w.to})
+		if metric.GetGauge().GetValue() != w.count {
+			t.Errorf("%s -> %s = %v, want %v", w.from, w.to, metric.GetGauge().GetValue(), w.count)
+		}
+	}
+}
+
+func TestLocalizedCategoryTransitions(t *testing.T) {
+	categories := &statusCategories{byID: map[string]StatusCategory{
+		"1": {Key: "new", Name: "À faire"},
+		"2": {Key: "new", Name: "Backlog"},
+		"3": {Key: "indeterminate", Name: "En cours"},
+		"4": {Key: "done", Name: "Terminé"},
+	}}
+	// Open -> Backlog stays within the category despite the different names
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000"},
+		"changelog": {"histories": [
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [{"field": "status", "from": "3", "to": "4"}]},
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "status", "from": "2", "to": "3"}]},
+			{"created": "2024-01-02T10:00:00.000+0000", "items": [{"field": "status", "from": "1", "to": "2"}]}]}}`)
+	got := categoryTransitions(issue, categories)
+	want := [][2]string{{"En cours", "Terminé"}, {"Backlog", "En cours"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("categoryTransitions() = %q, want %q", got, want)
+	}
+}
+
+func TestStatusCategoryCount(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	for i, status := range []string{
+		`{"name": "Open", "statusCategory": {"key": "new", "name": "To Do"}}`,
+		`{"name": "Backlog", "statusCategory": {"key": "new", "name": "To Do"}}`,
+		`{"name": "Review", "statusCategory": {"key": "indeterminate", "name": "In Progress"}}`,
+		`{"name": "Fermé", "statusCategory": {"key": "done", "name": "Terminé"}}`,
+		`{"name": "Done", "statusCategory": {"key": "done", "name": "Done"}}`,
+		`{"name": "Closed", "statusCategory": {"key": "done", "name": "Done"}}`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": %s,
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`, i, status)))
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_status_category_count")
+	if len(counts) != 3 {
+		t.Errorf("jira_issue_status_category_count has %d series, want 3", len(counts))
+	}
+	total := 0.0
+	for category, want := range map[string]float64{"new": 2, "indeterminate": 1, "done": 3} {
+		value := findMetric(counts, map[string]string{"project": "PROJ", "statusCategory": category}).GetGauge().GetValue()
+		if value != want {
+			t.Errorf("jira_issue_status_category_count{statusCategory=%q} = %v, want %v", category, value, want)
+		}
+		total += value
+	}
+	if total != 6 {
+		t.Errorf("the categories sum up to %v, want all 6 issues", total)
+	}
+}
+
+func TestDoneRatio(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.excludeIssueTypes = []string{"Epic"}
+	registry := registerTestMetrics(t, cfg)
+	issue := func(key, project, category, issueType string) JiraIssue {
+		return parseIssue(t, fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"status": {"name": "Status", "statusCategory": {"key": %q}},
+			"project": {"key": %q}, "issuetype": {"name": %q}}}`, key, category, project, issueType))
+	}
+	issueCollector.processInstance(cfg, []JiraIssue{
+		issue("PROJ-1", "PROJ", "done", "Task"),
+		issue("PROJ-2", "PROJ", "indeterminate", "Task"),
+		issue("PROJ-3", "PROJ", "new", "Task"),
+		issue("PROJ-4", "PROJ", "done", "Task"),
+		issue("PROJ-5", "PROJ", "new", "Epic"),
+		issue("OPEN-1", "OPEN", "new", "Task"),
+		issue("EPIC-1", "EPIC", "done", "Epic"),
+	})
+
+	ratios := gatherMetrics(t, registry, "jira_issue_done_ratio")
+	if len(ratios) != 2 {
+		t.Errorf("jira_issue_done_ratio has %d series, want 2 without the project of excluded issues", len(ratios))
+	}
+	for project, want := range map[string]float64{"PROJ": 0.5, "OPEN": 0} {
+		if metric := findMetric(ratios, map[string]string{"project": project}); metric == nil || metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_issue_done_ratio{project=%q} = %v, want %v", project, metric.GetGauge(), want)
+		}
+	}
+}
+
+func TestOldestOpenIssueAge(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	issue := func(key, project, category string, age time.Duration) JiraIssue {
+		return parseIssue(t, fmt.Sprintf(`{"key": %q, "fields": {"created": %q,
+			"status": {"name": "Status", "statusCategory": {"key": %q}},
+			"project": {"key": %q}, "issuetype": {"name": "Task"}}}`, key, now.Add(-age).Format(jiraTimeFormat), category, project))
+	}
+	issueCollector.processInstance(cfg, []JiraIssue{
+		issue("PROJ-1", "PROJ", "new", 2*day),
+		issue("PROJ-2", "PROJ", "indeterminate", 30*day),
+		issue("PROJ-3", "PROJ", "done", 400*day),
+		issue("PROJ-4", "PROJ", "new", 10*day),
+		issue("DONE-1", "DONE", "done", 50*day),
+	})
+
+	ages := gatherMetrics(t, registry, "jira_project_oldest_open_issue_age_seconds")
+	if len(ages) != 1 {
+		t.Errorf("jira_project_oldest_open_issue_age_seconds has %d series, want only PROJ with open issues", len(ages))
+	}
+	metric := findMetric(ages, map[string]string{"project": "PROJ"})
+	if age := time.Duration(metric.GetGauge().GetValue() * float64(time.Second)); age < 30*day || age > 30*day+time.Minute {
+		t.Errorf("jira_project_oldest_open_issue_age_seconds{project=\"PROJ\"} = %s, want the 30d of PROJ-2", age)
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector holds the metrics computed from the fetched issues. It's a single prometheus.Collector, so it can be
+// registered with any registry, e.g. a fresh one in the tests.
+type Collector struct {
+	// cfg is the config of the issues passed to Process
+	cfg config
+
+	issueCount        *prometheus.GaugeVec
+	issueTimeInStatus *issueTypeHistogramVec
+	// issueTimeInStatusSummary is nil unless enabled by the config
+	issueTimeInStatusSummary   *prometheus.SummaryVec
+	issueStoryPoints           *prometheus.GaugeVec
+	openIssueAge               *prometheus.HistogramVec
+	openIssueAgeBucketCount    *prometheus.GaugeVec
+	issueActiveUpdatedCount    *prometheus.GaugeVec
+	issuesCreated              *prometheus.GaugeVec
+	issuesResolved             *prometheus.GaugeVec
+	issueTimeToFirstTransition *prometheus.HistogramVec
+	issueTimeSinceLastChange   *prometheus.HistogramVec
+	issueResolutionTime        *prometheus.HistogramVec
+	issueCycleTime             *prometheus.HistogramVec
+	issueBlocked               *prometheus.GaugeVec
+	issueBlockedDuration       *prometheus.HistogramVec
+	issueLabelCount            *prometheus.GaugeVec
+	issueReporterCount         *prometheus.GaugeVec
+	issueDataQualityIssues     *prometheus.GaugeVec
+	issueChangelogEntries      *prometheus.HistogramVec
+	issueCommentCount          *prometheus.HistogramVec
+	issueLinkCount             *prometheus.HistogramVec
+	issueNegativeDurations     *prometheus.GaugeVec
+	issueSLABreaches           *prometheus.GaugeVec
+	issueFieldChanges          *prometheus.GaugeVec
+	issueCategoryTransitions   *prometheus.GaugeVec
+	issueStatusCategoryCount   *prometheus.GaugeVec
+	issueDoneRatio             *prometheus.GaugeVec
+	epicChildCount             *prometheus.GaugeVec
+	epicDoneRatio              *prometheus.GaugeVec
+	projectOldestOpenIssueAge  *prometheus.GaugeVec
+}
+
+// NewCollector creates the issue metrics with the configured namespace, subsystem and labels
+func NewCollector(cfg config) *Collector {
+	c := &Collector{cfg: cfg}
+	c.issueCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_count",
+			Help:        "Count of Jira issues by various labels.",
+		},
+		instanceLabelNames(cfg, cfg.countLabels...),
+	)
+	timeInStatusOpts := func(buckets []float64) prometheus.HistogramOpts {
+		return prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_time_in_status",
+			Help:        "Time spent by issues in each status.",
+			Buckets:     buckets,
+		}
+	}
+	timeInStatusLabels := instanceLabelNames(cfg, "project", "priority", "assignee", "issueType")
+	c.issueTimeInStatus = &issueTypeHistogramVec{
+		defaultVec:  prometheus.NewHistogramVec(timeInStatusOpts(prometheus.ExponentialBuckets(1, 10, 8)), timeInStatusLabels),
+		byIssueType: make(map[string]*prometheus.HistogramVec, len(cfg.issueTypeBuckets)),
+	}
+	for issueType, buckets := range cfg.issueTypeBuckets {
+		c.issueTimeInStatus.byIssueType[issueType] = prometheus.NewHistogramVec(timeInStatusOpts(buckets), timeInStatusLabels)
+	}
+	if cfg.enableStatusSummary {
+		c.issueTimeInStatusSummary = prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Namespace:   cfg.metricNamespace,
+				Subsystem:   cfg.metricSubsystem,
+				ConstLabels: cfg.staticLabels,
+				Name:        "jira_issue_time_in_status_summary",
+				Help:        "Quantiles of time spent by issues in each status.",
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+			instanceLabelNames(cfg, "project", "priority", "assignee", "issueType"),
+		)
+	}
+	c.issueStoryPoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_story_points",
+			Help:        "Sum of story points of Jira issues.",
+		},
+		instanceLabelNames(cfg, "project", "status"),
+	)
+	c.issuesCreated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issues_created_total",
+			Help:        "Count of Jira issues created within the analyze window.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issuesResolved = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issues_resolved_total",
+			Help:        "Count of Jira issues resolved within the analyze window.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.openIssueAge = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_open_issue_age_seconds",
+			Help:        "Age since creation of issues not in the done status category.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project", "status"),
+	)
+	c.openIssueAgeBucketCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_open_issue_age_bucket_count",
+			Help:        "Count of issues not in the done status category by age bracket since creation.",
+		},
+		instanceLabelNames(cfg, "project", "status", "bucket"),
+	)
+	c.issueActiveUpdatedCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_active_updated_count",
+			Help:        "Count of issues updated within the analyze window but not in the done status category.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.projectOldestOpenIssueAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_project_oldest_open_issue_age_seconds",
+			Help:        "Age since creation of the oldest issue of the project not in the done status category.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueDoneRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_done_ratio",
+			Help:        "Ratio of Jira issues in the done status category to all issues of the project.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.epicChildCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_epic_child_count",
+			Help:        "Count of Jira issues in the epic.",
+		},
+		instanceLabelNames(cfg, "project", "epic"),
+	)
+	c.epicDoneRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_epic_done_ratio",
+			Help:        "Ratio of Jira issues in the done status category to all issues of the epic.",
+		},
+		instanceLabelNames(cfg, "project", "epic"),
+	)
+	c.issueResolutionTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_resolution_time_seconds",
+			Help:        "Time from issue creation to its resolution by priority.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project", "priority"),
+	)
+	c.issueCycleTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_cycle_time_seconds",
+			Help:        "Time from the first transition into a cycle time start status to the issue resolution.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project", "issueType"),
+	)
+	c.issueBlocked = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_blocked_total",
+			Help:        "Count of Jira issues that have ever been in a blocked status.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueBlockedDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_blocked_duration_seconds",
+			Help:        "Total time spent by issues in the blocked statuses they have left.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueTimeSinceLastChange = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_time_since_last_change_seconds",
+			Help:        "Time since the latest changelog entry of the issue.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueTimeToFirstTransition = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_time_to_first_transition_seconds",
+			Help:        "Time from issue creation to its first status change.",
+			Buckets:     prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		instanceLabelNames(cfg, "project", "issueType"),
+	)
+	c.issueReporterCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_reporter_count",
+			Help:        "Number of Jira issues by reporter.",
+		},
+		instanceLabelNames(cfg, "project", "reporter"),
+	)
+	c.issueLabelCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_label_count",
+			Help:        "Count of Jira issues by label.",
+		},
+		instanceLabelNames(cfg, "project", "label"),
+	)
+	c.issueDataQualityIssues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_data_quality_issues_total",
+			Help:        "Count of Jira issues with missing or invalid data by kind.",
+		},
+		instanceLabelNames(cfg, "project", "kind"),
+	)
+	c.issueSLABreaches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_sla_breach_total",
+			Help:        "Number of issues that spent more than the SLA threshold in the status.",
+		},
+		instanceLabelNames(cfg, "project", "status"),
+	)
+	c.issueNegativeDurations = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_negative_duration_total",
+			Help:        "Count of negative status durations clamped to zero, e.g. of status changes dated before the issue creation.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueFieldChanges = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_field_changes_total",
+			Help:        "Count of changes of the tracked fields in the changelogs of Jira issues.",
+		},
+		instanceLabelNames(cfg, "project", "field"),
+	)
+	c.issueChangelogEntries = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_changelog_entries",
+			Help:        "Number of changelog entries per issue.",
+			Buckets:     prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	c.issueCommentCount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_comment_count",
+			Help:        "Number of comments per issue.",
+			Buckets:     prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		instanceLabelNames(cfg, "project", "issueType"),
+	)
+	c.issueLinkCount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_link_count",
+			Help:        "Number of links to other issues per issue.",
+			Buckets:     prometheus.ExponentialBuckets(1, 2, 6),
+		},
+		instanceLabelNames(cfg, "project", "issueType"),
+	)
+	c.issueCategoryTransitions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_category_transitions_total",
+			Help:        "Count of status changes between status categories in the changelogs of Jira issues.",
+		},
+		instanceLabelNames(cfg, "project", "from_category", "to_category"),
+	)
+	c.issueStatusCategoryCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_status_category_count",
+			Help:        "Count of Jira issues by the key of the status category.",
+		},
+		instanceLabelNames(cfg, "project", "statusCategory"),
+	)
+	return c
+}
+
+// namedCollectors returns the metric vectors by name, skipping the ones disabled by the options
+func (c *Collector) namedCollectors() []namedCollector {
+	named := []namedCollector{
+		{"jira_issue_count", c.issueCount},
+		{"jira_issue_time_in_status", c.issueTimeInStatus},
+		{"jira_issue_story_points", c.issueStoryPoints},
+		{"jira_open_issue_age_seconds", c.openIssueAge},
+		{"jira_open_issue_age_bucket_count", c.openIssueAgeBucketCount},
+		{"jira_issue_active_updated_count", c.issueActiveUpdatedCount},
+		{"jira_issues_created_total", c.issuesCreated},
+		{"jira_issues_resolved_total", c.issuesResolved},
+		{"jira_issue_time_to_first_transition_seconds", c.issueTimeToFirstTransition},
+		{"jira_issue_time_since_last_change_seconds", c.issueTimeSinceLastChange},
+		{"jira_issue_resolution_time_seconds", c.issueResolutionTime},
+		{"jira_issue_cycle_time_seconds", c.issueCycleTime},
+		{"jira_issue_blocked_total", c.issueBlocked},
+		{"jira_issue_blocked_duration_seconds", c.issueBlockedDuration},
+		{"jira_issue_label_count", c.issueLabelCount},
+		{"jira_issue_reporter_count", c.issueReporterCount},
+		{"jira_issue_data_quality_issues_total", c.issueDataQualityIssues},
+		{"jira_issue_changelog_entries", c.issueChangelogEntries},
+		{"jira_issue_comment_count", c.issueCommentCount},
+		{"jira_issue_link_count", c.issueLinkCount},
+		{"jira_issue_negative_duration_total", c.issueNegativeDurations},
+		{"jira_issue_sla_breach_total", c.issueSLABreaches},
+		{"jira_issue_field_changes_total", c.issueFieldChanges},
+		{"jira_issue_category_transitions_total", c.issueCategoryTransitions},
+		{"jira_issue_status_category_count", c.issueStatusCategoryCount},
+		{"jira_issue_done_ratio", c.issueDoneRatio},
+		{"jira_epic_child_count", c.epicChildCount},
+		{"jira_epic_done_ratio", c.epicDoneRatio},
+		{"jira_project_oldest_open_issue_age_seconds", c.projectOldestOpenIssueAge},
+	}
+	if c.issueTimeInStatusSummary != nil {
+		named = append(named, namedCollector{"jira_issue_time_in_status_summary", c.issueTimeInStatusSummary})
+	}
+	return named
+}
+
+// collectors returns the metric vectors enabled by ENABLED_METRICS and DISABLED_METRICS
+func (c *Collector) collectors() []prometheus.Collector {
+	return enabledCollectors(c.cfg, c.namedCollectors())
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// Reset deletes the metrics of the previous issues before a refresh
+func (c *Collector) Reset() {
+	c.issueCount.Reset()
+	c.issueTimeInStatus.Reset()
+	c.issueStoryPoints.Reset()
+	c.openIssueAge.Reset()
+	c.openIssueAgeBucketCount.Reset()
+	c.issueActiveUpdatedCount.Reset()
+	c.issuesCreated.Reset()
+	c.issuesResolved.Reset()
+	c.issueTimeToFirstTransition.Reset()
+	c.issueTimeSinceLastChange.Reset()
+	c.issueResolutionTime.Reset()
+	c.issueCycleTime.Reset()
+	c.issueBlocked.Reset()
+	c.issueBlockedDuration.Reset()
+	c.issueLabelCount.Reset()
+	c.issueReporterCount.Reset()
+	c.issueDataQualityIssues.Reset()
+	c.issueChangelogEntries.Reset()
+	c.issueCommentCount.Reset()
+	c.issueLinkCount.Reset()
+	c.issueNegativeDurations.Reset()
+	c.issueSLABreaches.Reset()
+	c.issueFieldChanges.Reset()
+	c.issueCategoryTransitions.Reset()
+	c.issueStatusCategoryCount.Reset()
+	c.issueDoneRatio.Reset()
+	c.epicChildCount.Reset()
+	c.epicDoneRatio.Reset()
+	c.projectOldestOpenIssueAge.Reset()
+	if c.issueTimeInStatusSummary != nil {
+		c.issueTimeInStatusSummary.Reset()
+	}
+}
+
+// Process updates the metrics with the issues. The metrics add up until Reset, so the issues
+// of several instances can be processed in turn. It returns the time spent processing the changelogs.
+func (c *Collector) Process(issues []JiraIssue) time.Duration {
+	return c.processInstance(c.cfg, issues)
+}
+
+// processIssue updates the metrics with the issue of the instance. It returns the time spent computing
+// the status durations from the changelog.
+func (c *Collector) processIssue(cfg config, issue JiraIssue) time.Duration {
+	if !isIssueEmitted(cfg, issue, cfg.now()) {
+		return 0
+	}
+	//fmt.Printf("Processing issue %s\n", issue.Key)
+	allLabels := prometheus.Labels{
+		"project":        issue.Fields.Project.Key,
+		"priority":       priorityName(issue),
+		"status":         issue.Fields.Status.Name,
+		"statusCategory": issue.Fields.Status.StatusCategory.Name,
+		"assignee":       assigneeLabel(cfg, issue),
+		"issueType":      issue.Fields.IssueType.Name,
+		"parentKey":      parentKey(issue),
+	}
+	if cfg.sprintField != "" {
+		allLabels["sprint"] = sprintName(issue, cfg.sprintField)
+		allLabels["sprintState"] = sprintState(issue, cfg.sprintField)
+	}
+	// Issues that differ only in the dropped labels are aggregated into the same series
+	countLabels := make(prometheus.Labels, len(cfg.countLabels))
+	for _, label := range cfg.countLabels {
+		countLabels[label] = allLabels[label]
+	}
+	c.issueCount.With(withInstanceLabel(cfg, countLabels)).Inc()
+	c.issueStatusCategoryCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, issue.Fields.Status.StatusCategory.Key)...).Inc()
+	if cfg.storyPointsField != "" {
+		if points, ok := numericCustomField(issue, cfg.storyPointsField); ok {
+			c.issueStoryPoints.With(withInstanceLabel(cfg, prometheus.Labels{
+				"project": issue.Fields.Project.Key,
+				"status":  issue.Fields.Status.Name,
+			})).Add(points)
+		}
+	}
+	for _, label := range issue.Fields.Labels {
+		c.issueLabelCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, label)...).Inc()
+	}
+	c.issueChangelogEntries.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Observe(float64(len(issue.Changelog.Histories)))
+	if slices.Contains(cfg.fields, "reporter") {
+		c.issueReporterCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, reporterLabel(cfg, issue))...).Inc()
+	}
+	if slices.Contains(cfg.fields, "comment") {
+		c.issueCommentCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, issue.Fields.IssueType.Name)...).Observe(float64(issue.Fields.Comment.Total))
+	}
+	// Without the field, the issues would be observed as not linked
+	if slices.Contains(cfg.fields, "issuelinks") {
+		c.issueLinkCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, issue.Fields.IssueType.Name)...).Observe(float64(len(issue.Fields.IssueLinks)))
+	}
+	if wasBlocked(issue, cfg.blockedStatuses) {
+		c.issueBlocked.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Inc()
+	}
+	for _, kind := range dataQualityIssues(cfg, issue) {
+		c.issueDataQualityIssues.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, kind)...).Inc()
+	}
+	for field, changes := range fieldChanges(issue, cfg.trackFields) {
+		c.issueFieldChanges.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, field)...).Add(float64(changes))
+	}
+	for _, transition := range categoryTransitions(issue, cfg.statusCategories) {
+		c.issueCategoryTransitions.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, transition[0], transition[1])...).Inc()
+	}
+	// The time metrics can't be computed without valid timestamps
+	if !hasValidTimestamps(issue) {
+		return 0
+	}
+	created := mustTimeParse(issue.Fields.Created)
+	if !created.Before(windowStart(cfg, issue.Fields.Project.Key, cfg.now())) {
+		c.issuesCreated.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Inc()
+	}
+	// Touched but not finished work
+	if updated, err := parseJiraTime(issue.Fields.Updated); err == nil && !isDone(issue) &&
+		!updated.Before(windowStart(cfg, issue.Fields.Project.Key, cfg.now())) {
+		c.issueActiveUpdatedCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Inc()
+	}
+	if !isDone(issue) {
+		age := cfg.now().Sub(created)
+		c.openIssueAge.With(withInstanceLabel(cfg, prometheus.Labels{
+			"project": issue.Fields.Project.Key,
+			"status":  issue.Fields.Status.Name,
+		})).Observe(age.Seconds())
+		c.openIssueAgeBucketCount.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, issue.Fields.Status.Name, ageBucket(age, cfg.openAgeBuckets))...).Inc()
+	}
+	// Unresolved issues have no resolution date
+	if resolved, err := parseJiraTime(issue.Fields.ResolutionDate); err == nil {
+		if !resolved.Before(windowStart(cfg, issue.Fields.Project.Key, cfg.now())) {
+			c.issuesResolved.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Inc()
+		}
+		if !resolved.Before(created) {
+			c.issueResolutionTime.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, priorityName(issue))...).Observe(resolved.Sub(created).Seconds())
+		}
+		if started, ok := cycleTimeStart(issue, cfg.cycleTimeStartStatuses); ok && !resolved.Before(started) {
+			c.issueCycleTime.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, issue.Fields.IssueType.Name)...).Observe(resolved.Sub(started).Seconds())
+		}
+	}
+	if firstTransition, ok := firstStatusTransition(issue); ok {
+		c.issueTimeToFirstTransition.With(withInstanceLabel(cfg, prometheus.Labels{
+			"project":   issue.Fields.Project.Key,
+			"issueType": issue.Fields.IssueType.Name,
+		})).Observe(firstTransition.Sub(created).Seconds())
+	}
+	if changed, ok := lastChange(issue); ok {
+		c.issueTimeSinceLastChange.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Observe(cfg.now().Sub(changed).Seconds())
+	}
+	started := time.Now()
+	c.observeStatusDurations(cfg, issue)
+	return time.Since(started)
+}
+
+// reporterCardinalityWarning is the number of distinct reporters of an instance that triggers the cardinality warning
+const reporterCardinalityWarning = 500
+
+// processInstance updates the metrics with the issues of the instance, including the metrics aggregated
+// over all of them. It returns the time spent processing the changelogs.
+func (c *Collector) processInstance(cfg config, issues []JiraIssue) time.Duration {
+	done := make(map[string]int)
+	total := make(map[string]int)
+	oldestOpen := make(map[string]time.Time)
+	reporters := make(map[string]bool)
+	// The epics are keyed by project and epic key, as the children of an epic can be in other projects
+	epicDone := make(map[[2]string]int)
+	epicTotal := make(map[[2]string]int)
+	var changelogDuration time.Duration
+	for _, issue := range issues {
+		changelogDuration += c.processIssue(cfg, issue)
+		if !isIssueEmitted(cfg, issue, cfg.now()) {
+			continue
+		}
+		reporters[reporterLabel(cfg, issue)] = true
+		project := issue.Fields.Project.Key
+		total[project]++
+		epic := [2]string{project, epicKey(issue, cfg.epicField)}
+		if cfg.epicField != "" {
+			epicTotal[epic]++
+		}
+		if isDone(issue) {
+			done[project]++
+			epicDone[epic]++
+			continue
+		}
+		created, err := parseJiraTime(issue.Fields.Created)
+		if oldest, ok := oldestOpen[project]; err == nil && (!ok || created.Before(oldest)) {
+			oldestOpen[project] = created
+		}
+	}
+	// Projects without issues have no ratio
+	for project, count := range total {
+		c.issueDoneRatio.WithLabelValues(instanceLabelValues(cfg, project)...).Set(float64(done[project]) / float64(count))
+	}
+	// Issues without an epic are rolled up into epic="none"
+	for epic, count := range epicTotal {
+		c.epicChildCount.WithLabelValues(instanceLabelValues(cfg, epic[0], epic[1])...).Set(float64(count))
+		c.epicDoneRatio.WithLabelValues(instanceLabelValues(cfg, epic[0], epic[1])...).Set(float64(epicDone[epic]) / float64(count))
+	}
+	if slices.Contains(cfg.fields, "reporter") && len(reporters) > reporterCardinalityWarning {
+		fmt.Printf("Warning: jira_issue_reporter_count of %s has %d reporters, remove reporter from JIRA_FIELDS to drop the series\n",
+			cfg.jiraURL, len(reporters))
+	}
+	// Projects without open issues have no oldest one
+	for project, created := range oldestOpen {
+		c.projectOldestOpenIssueAge.WithLabelValues(instanceLabelValues(cfg, project)...).Set(cfg.now().Sub(created).Seconds())
+	}
+	return changelogDuration
+}
+
+// observeStatusDurations observes the time the issue spent in each of its previous statuses. The statuses are
+// observed in the sorted order, so the processing is the same for the same changelog.
+func (c *Collector) observeStatusDurations(cfg config, issue JiraIssue) {
+	durations, negative := statusDurations(cfg, issue)
+	if negative > 0 {
+		c.issueNegativeDurations.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Add(float64(negative))
+	}
+	// The time in the current status isn't known until the issue leaves it
+	var blocked time.Duration
+	leftBlocked := false
+	for _, status := range sortedStatuses(durations) {
+		duration := durations[status]
+		if isStatusIn(status, cfg.blockedStatuses) {
+			blocked += duration
+			leftBlocked = true
+		}
+		// The total time of the issue in the status is compared, also if it entered the status several times
+		if threshold, ok := cfg.slaThresholds[status]; ok && duration > threshold {
+			c.issueSLABreaches.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key, status)...).Inc()
+		}
+		// The time in the ignored statuses still separates the times of the neighboring statuses
+		if slices.Contains(cfg.ignoreStatuses, status) {
+			continue
+		}
+		//fmt.Printf("Issue %s spent %s in status %s\n", issue.Key, duration, status)
+		labels := withInstanceLabel(cfg, prometheus.Labels{
+			"project":   issue.Fields.Project.Key,
+			"priority":  priorityName(issue),
+			"assignee":  assigneeLabel(cfg, issue),
+			"issueType": issue.Fields.IssueType.Name,
+		})
+		if c.issueTimeInStatusSummary != nil {
+			c.issueTimeInStatusSummary.With(labels).Observe(duration.Seconds())
+		}
+		observer := c.issueTimeInStatus.With(labels)
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && cfg.enableExemplars {
+			exemplarObserver.ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"issueKey": issue.Key})
+			continue
+		}
+		observer.Observe(duration.Seconds())
+	}
+	if leftBlocked {
+		c.issueBlockedDuration.WithLabelValues(instanceLabelValues(cfg, issue.Fields.Project.Key)...).Observe(blocked.Seconds())
+	}
+}
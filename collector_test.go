@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.enableStatusSummary = true
+	registry := prometheus.NewRegistry()
+	collector := NewCollector(cfg)
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	otherRegistry := prometheus.NewRegistry()
+	other := NewCollector(cfg)
+	if err := otherRegistry.Register(other); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.Process([]JiraIssue{
+		parseIssue(t, testIssueWithTransitionJSON("PROJ-1")),
+		parseIssue(t, testIssueWithTransitionJSON("PROJ-2")),
+	})
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if len(counts) != 1 || counts[0].GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issue_count = %v, want a series of 2 issues", counts)
+	}
+	for _, name := range []string{"jira_issue_time_in_status", "jira_issue_time_in_status_summary"} {
+		if metrics := gatherMetrics(t, registry, name); len(metrics) == 0 {
+			t.Errorf("%s has no series", name)
+		}
+	}
+	// The collectors don't share the series
+	other.Process([]JiraIssue{parseIssue(t, testIssueWithTransitionJSON("PROJ-3"))})
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issue_count = %v after processing another collector, want 2", counts)
+	}
+	if counts := gatherMetrics(t, otherRegistry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_issue_count of the other collector = %v, want 1", counts)
+	}
+
+	collector.Reset()
+	if metrics := gatherMetrics(t, registry, "jira_issue_count"); metrics != nil {
+		t.Errorf("jira_issue_count = %v after Reset, want no series", metrics)
+	}
+}
+
+func TestCollectorWithoutSummary(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	collector := NewCollector(testConfig(t))
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	collector.Process([]JiraIssue{parseIssue(t, testIssueWithTransitionJSON("PROJ-1"))})
+	if metrics := gatherMetrics(t, registry, "jira_issue_time_in_status_summary"); metrics != nil {
+		t.Errorf("jira_issue_time_in_status_summary = %v, want it disabled", metrics)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSettings is the settings of CONFIG_FILE by env name. The envs take precedence over them.
+var fileSettings map[string]string
+
+// loadConfigFile reads the YAML file of settings named as the envs, e.g. JIRA_URL or jira_url.
+// Lists are joined with commas, so JIRA_PROJECTS can be given as a YAML list. An empty path loads nothing.
+func loadConfigFile(path string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if path == "" {
+		return settings, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	for key, value := range raw {
+		s, err := settingValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %s: %w", path, key, err)
+		}
+		settings[strings.ToUpper(key)] = s
+	}
+	return settings, nil
+}
+
+// settingValue formats the YAML value as the env value
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("nested settings are not supported")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+jira_url: https://file.example.com
+JIRA_USER: file-user
+jira_projects: [PROJ, OPS:30]
+data_refresh_period: 10m
+debug_endpoints_enabled: true
+jira_rps: 2.5
+refresh_jitter:
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"JIRA_URL":                "https://file.example.com",
+		"JIRA_USER":               "file-user",
+		"JIRA_PROJECTS":           "PROJ,OPS:30",
+		"DATA_REFRESH_PERIOD":     "10m",
+		"DEBUG_ENDPOINTS_ENABLED": "true",
+		"JIRA_RPS":                "2.5",
+		"REFRESH_JITTER":          "",
+	}
+	if !maps.Equal(settings, want) {
+		t.Errorf("loadConfigFile() = %q, want %q", settings, want)
+	}
+
+	previous := fileSettings
+	fileSettings = settings
+	t.Cleanup(func() { fileSettings = previous })
+	t.Setenv("JIRA_URL", "https://env.example.com")
+	t.Setenv("JIRA_USER", "")
+	os.Unsetenv("JIRA_USER")
+	t.Setenv("JIRA_PROJECTS", "")
+	for name, want := range map[string]string{
+		"JIRA_URL":            "https://env.example.com",
+		"JIRA_USER":           "file-user",
+		"JIRA_PROJECTS":       "",
+		"DATA_REFRESH_PERIOD": "10m",
+	} {
+		if got := lookupEnv(name); got != want {
+			t.Errorf("lookupEnv(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"malformed": "jira_url: [https://jira.example.com\n",
+		"not a map": "- JIRA_URL\n",
+		"nested":    "jira:\n  url: https://jira.example.com\n",
+	} {
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfigFile(path); err == nil {
+			t.Errorf("loadConfigFile() of the %s file succeeded, want an error", name)
+		}
+	}
+	if _, err := loadConfigFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("loadConfigFile() of a missing file succeeded, want an error")
+	}
+	if settings, err := loadConfigFile(""); err != nil || len(settings) != 0 {
+		t.Errorf("loadConfigFile(\"\") = %v, %v, want no settings", settings, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+)
+
+// debugIssueHandler fetches a single issue by the key query parameter and returns it as decoded by the exporter,
+// along with the computed status durations and the problems found in its data. The durations are omitted
+// if the timestamps can't be parsed. With multiple instances, the instance query parameter selects
+// the instance, the first one by default.
+func debugIssueHandler(cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key query parameter is required", http.StatusBadRequest)
+			return
+		}
+		cfg, ok := findInstance(cfg, r.URL.Query().Get("instance"))
+		if !ok {
+			http.Error(w, "unknown instance", http.StatusBadRequest)
+			return
+		}
+		apiURL := fmt.Sprintf("%s/rest/api/3/issue/%s?expand=changelog&fields=%s", cfg.jiraURL, url.PathEscape(key), searchFields(cfg))
+		var issue JiraIssue
+		if err := getJSON(r.Context(), cfg, apiURL, &issue); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		issues := []JiraIssue{issue}
+		if err := completeChangelogs(r.Context(), cfg, issues); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		problems := dataQualityIssues(cfg, issues[0])
+		if hasUnnamedStatusChanges(issues[0]) {
+			problems = append(problems, "unnamed_status_change")
+		}
+		durations := make(map[string]string)
+		if hasValidTimestamps(issues[0]) {
+			computed, _ := statusDurations(cfg, issues[0])
+			for status, duration := range computed {
+				durations[status] = duration.String()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(struct {
+			Issue           JiraIssue         `json:"issue"`
+			StatusDurations map[string]string `json:"statusDurations"`
+			Problems        []string          `json:"problems"`
+		}{issues[0], durations, problems})
+	})
+}
+
+// hasUnnamedStatusChanges checks for status changes without the name of the previous status in the changelog,
+// which are skipped by the status durations
+func hasUnnamedStatusChanges(issue JiraIssue) bool {
+	for _, history := range issue.Changelog.Histories {
+		for _, item := range history.Items {
+			if _, ok := item.FromString.(string); item.Field == "status" && !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configHandler returns the effective config with the secrets redacted, and the JQL queries of the instances
+// as they would be sent now
+func configHandler(cfg config) http.Handler {
+	type instanceView struct {
+		Name              string   `json:"name,omitempty"`
+		JiraURL           string   `json:"jiraURL"`
+		JiraUser          string   `json:"jiraUser,omitempty"`
+		JiraAPIToken      string   `json:"jiraAPIToken,omitempty"`
+		OAuthTokenURL     string   `json:"oauthTokenURL,omitempty"`
+		OAuthClientID     string   `json:"oauthClientID,omitempty"`
+		OAuthClientSecret string   `json:"oauthClientSecret,omitempty"`
+		JQL               []string `json:"jql"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := cfg.now()
+		instances := make([]instanceView, 0, len(cfg.instances))
+		for _, instance := range cfg.instances {
+			view := instanceView{
+				Name:         instance.instance,
+				JiraURL:      instance.jiraURL,
+				JiraUser:     instance.jiraUser,
+				JiraAPIToken: redact(instance.jiraAPIToken),
+				JQL:          make([]string, 0),
+			}
+			if instance.oauth != nil {
+				view.OAuthTokenURL = instance.oauth.TokenURL
+				view.OAuthClientID = instance.oauth.ClientID
+				view.OAuthClientSecret = redact(instance.oauth.ClientSecret)
+			}
+			for _, window := range queryWindows(instance) {
+				view.JQL = append(view.JQL, buildJQL(instance, window, now))
+			}
+			instances = append(instances, view)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(struct {
+			Instances           []instanceView `json:"instances"`
+			DataRefreshPeriod   string         `json:"dataRefreshPeriod"`
+			RefreshJitter       float64        `json:"refreshJitter"`
+			FullRefreshInterval string         `json:"fullRefreshInterval"`
+			RequestTimeout      string         `json:"requestTimeout"`
+			AnalyzePeriod       string         `json:"analyzePeriod"`
+			WindowField         string         `json:"windowField"`
+			Fields              []string       `json:"fields"`
+			CountLabels         []string       `json:"countLabels"`
+			IncludeIssueTypes   []string       `json:"includeIssueTypes"`
+			ExcludeIssueTypes   []string       `json:"excludeIssueTypes"`
+			StoryPointsField    string         `json:"storyPointsField"`
+			SprintField         string         `json:"sprintField"`
+			Timezone            string         `json:"timezone"`
+			BusinessHours       bool           `json:"businessHours"`
+			TokenPagination     bool           `json:"tokenPagination"`
+			MetricNamespace     string         `json:"metricNamespace"`
+			MetricSubsystem     string         `json:"metricSubsystem"`
+		}{
+			Instances:           instances,
+			DataRefreshPeriod:   cfg.dataRefreshPeriod.String(),
+			RefreshJitter:       cfg.refreshJitter,
+			FullRefreshInterval: cfg.fullRefreshInterval.String(),
+			RequestTimeout:      cfg.requestTimeout.String(),
+			AnalyzePeriod:       cfg.analyzePeriod,
+			WindowField:         cfg.windowField,
+			Fields:              cfg.fields,
+			CountLabels:         cfg.countLabels,
+			IncludeIssueTypes:   cfg.includeIssueTypes,
+			ExcludeIssueTypes:   cfg.excludeIssueTypes,
+			StoryPointsField:    cfg.storyPointsField,
+			SprintField:         cfg.sprintField,
+			Timezone:            cfg.location.String(),
+			BusinessHours:       cfg.businessCalendar != nil,
+			TokenPagination:     cfg.tokenPagination,
+			MetricNamespace:     cfg.metricNamespace,
+			MetricSubsystem:     cfg.metricSubsystem,
+		})
+	})
+}
+
+// redact hides a non-empty secret, keeping it visible whether the secret is set
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// servePprof serves the pprof endpoints on a separate listener, so they aren't exposed with /metrics
+func servePprof(ctx context.Context, addr string) {
+	fmt.Printf("Serving pprof on %s\n", addr)
+	serve(ctx, addr, pprofHandler())
+}
+
+// pprofHandler routes the pprof endpoints
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
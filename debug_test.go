@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+func TestDebugIssueHandler(t *testing.T) {
+	issues := map[string]string{
+		"PROJ-1": testIssueWithTransitionJSON("PROJ-1"),
+		"PROJ-2": `{"key": "PROJ-2", "fields": {"created": "yesterday", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+		"PROJ-3": `{"key": "PROJ-3", "fields": {"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+			"changelog": {"histories": [{"created": "2024-01-02T10:00:00.000+0000",
+				"items": [{"field": "status", "fromString": null, "toString": "Open"}]}]}}`,
+	}
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issue, ok := issues[r.URL.Path[len("/rest/api/3/issue/"):]]
+		if !ok || r.URL.Query().Get("expand") != "changelog" {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(issue))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.instances = []config{cfg}
+	registerTestMetrics(t, cfg)
+	handler := debugIssueHandler(cfg)
+
+	tests := []struct {
+		key           string
+		wantDurations map[string]string
+		wantProblems  []string
+	}{
+		{"PROJ-1", map[string]string{"Open": "24h0m0s"}, []string{"no_assignee", "no_priority"}},
+		{"PROJ-2", map[string]string{}, []string{"no_assignee", "no_priority", "bad_timestamp"}},
+		{"PROJ-3", map[string]string{}, []string{"no_assignee", "no_priority", "unnamed_status_change"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/issue?key="+tt.key, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body)
+			}
+			var body struct {
+				Issue           JiraIssue         `json:"issue"`
+				StatusDurations map[string]string `json:"statusDurations"`
+				Problems        []string          `json:"problems"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Issue.Key != tt.key {
+				t.Errorf("issue key = %q, want %q", body.Issue.Key, tt.key)
+			}
+			if len(body.StatusDurations) != len(tt.wantDurations) || body.StatusDurations["Open"] != tt.wantDurations["Open"] {
+				t.Errorf("statusDurations = %v, want %v", body.StatusDurations, tt.wantDurations)
+			}
+			if !slices.Equal(body.Problems, tt.wantProblems) {
+				t.Errorf("problems = %q, want %q", body.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestDebugIssueHandlerWithoutKey(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	cfg := testConfig(t)
+	cfg.instances = []config{cfg}
+	debugIssueHandler(cfg).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/issue", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPprofOnlyOnItsListener(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.debugEndpointsEnabled = true
+	registerTestMetrics(t, cfg)
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"pprof listener", pprofHandler(), http.StatusOK},
+		{"metrics listener", metricsHandler(cfg), http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			tt.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	cfg := testConfig(t)
+	cloud, legacy := cfg, cfg
+	cloud.instance, cloud.jiraURL, cloud.jiraUser, cloud.jiraAPIToken = "cloud", "https://cloud.example.com", "exporter", "api-token-secret"
+	legacy.instance, legacy.jiraURL = "legacy", "https://legacy.example.com"
+	legacy.oauth = &clientcredentials.Config{TokenURL: "https://auth.example.com/token", ClientID: "exporter", ClientSecret: "client-secret"}
+	cfg.instances = []config{cloud, legacy}
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(cfg).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body)
+	}
+	for _, secret := range []string{"api-token-secret", "client-secret"} {
+		if strings.Contains(recorder.Body.String(), secret) {
+			t.Errorf("the config exposes the secret %q: %s", secret, recorder.Body)
+		}
+	}
+	var body struct {
+		Instances []struct {
+			Name              string   `json:"name"`
+			JiraAPIToken      string   `json:"jiraAPIToken"`
+			OAuthClientSecret string   `json:"oauthClientSecret"`
+			JQL               []string `json:"jql"`
+		} `json:"instances"`
+		Fields []string `json:"fields"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Instances) != 2 {
+		t.Fatalf("%d instances, want 2", len(body.Instances))
+	}
+	if body.Instances[0].JiraAPIToken != "REDACTED" || body.Instances[1].OAuthClientSecret != "REDACTED" {
+		t.Errorf("secrets = %q, %q, want them redacted", body.Instances[0].JiraAPIToken, body.Instances[1].OAuthClientSecret)
+	}
+	want := []string{"updated >= -90d AND project in (PROJ)"}
+	for _, instance := range body.Instances {
+		if !slices.Equal(instance.JQL, want) {
+			t.Errorf("%s JQL = %q, want %q", instance.Name, instance.JQL, want)
+		}
+	}
+	if !slices.Equal(body.Fields, cfg.fields) {
+		t.Errorf("fields = %q, want %q", body.Fields, cfg.fields)
+	}
+}
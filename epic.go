@@ -0,0 +1,23 @@
+package main
+
+import "encoding/json"
+
+// epicKey returns the key of the issue's epic from the epic link custom field, or "none". Depending on
+// the Jira version, the field holds either the key or an object with it.
+func epicKey(issue JiraIssue, field string) string {
+	raw, ok := issue.CustomFields[field]
+	if !ok {
+		return "none"
+	}
+	var key string
+	if err := json.Unmarshal(raw, &key); err == nil && key != "" {
+		return key
+	}
+	var epic struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(raw, &epic); err == nil && epic.Key != "" {
+		return epic.Key
+	}
+	return "none"
+}
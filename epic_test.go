@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestEpicKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{"absent", ``, "none"},
+		{"null", `null`, "none"},
+		{"empty", `""`, "none"},
+		{"key", `"PROJ-10"`, "PROJ-10"},
+		{"object", `{"id": "10010", "key": "PROJ-10"}`, "PROJ-10"},
+		{"not a key", `42`, "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := JiraIssue{}
+			if tt.field != "" {
+				issue.CustomFields = map[string]json.RawMessage{"customfield_10014": json.RawMessage(tt.field)}
+			}
+			if got := epicKey(issue, "customfield_10014"); got != tt.want {
+				t.Errorf("epicKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEpicRollup(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.epicField = "customfield_10014"
+	registry := registerTestMetrics(t, cfg)
+	issue := func(key, project, epic, category string) JiraIssue {
+		return parseIssue(t, fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"status": {"name": "Status", "statusCategory": {"key": %q}}, "customfield_10014": %s,
+			"project": {"key": %q}, "issuetype": {"name": "Task"}}}`, key, category, epic, project))
+	}
+	issueCollector.processInstance(cfg, []JiraIssue{
+		// PROJ-10
+		issue("PROJ-1", "PROJ", `"PROJ-10"`, "done"),
+		issue("PROJ-2", "PROJ", `"PROJ-10"`, "indeterminate"),
+		issue("PROJ-3", "PROJ", `"PROJ-10"`, "done"),
+		issue("PROJ-4", "PROJ", `"PROJ-10"`, "new"),
+		// PROJ-20
+		issue("PROJ-5", "PROJ", `"PROJ-20"`, "done"),
+		// A child of PROJ-10 in another project
+		issue("OPEN-1", "OPEN", `"PROJ-10"`, "new"),
+		// Without an epic
+		issue("PROJ-6", "PROJ", `null`, "new"),
+		issue("PROJ-7", "PROJ", `""`, "done"),
+	})
+
+	counts := gatherMetrics(t, registry, "jira_epic_child_count")
+	ratios := gatherMetrics(t, registry, "jira_epic_done_ratio")
+	if len(counts) != 4 || len(ratios) != 4 {
+		t.Errorf("the epic metrics have %d and %d series, want 4", len(counts), len(ratios))
+	}
+	for _, tt := range []struct {
+		project, epic string
+		count, ratio  float64
+	}{
+		{"PROJ", "PROJ-10", 4, 0.5},
+		{"PROJ", "PROJ-20", 1, 1},
+		{"OPEN", "PROJ-10", 1, 0},
+		{"PROJ", "none", 2, 0.5},
+	} {
+		labels := map[string]string{"project": tt.project, "epic": tt.epic}
+		if metric := findMetric(counts, labels); metric.GetGauge().GetValue() != tt.count {
+			t.Errorf("jira_epic_child_count%v = %v, want %v", labels, metric.GetGauge().GetValue(), tt.count)
+		}
+		if metric := findMetric(ratios, labels); metric.GetGauge().GetValue() != tt.ratio {
+			t.Errorf("jira_epic_done_ratio%v = %v, want %v", labels, metric.GetGauge().GetValue(), tt.ratio)
+		}
+	}
+
+	// Without the field, there are no epics to roll up
+	cfg.epicField = ""
+	registry = registerTestMetrics(t, cfg)
+	issueCollector.processInstance(cfg, []JiraIssue{issue("PROJ-1", "PROJ", `"PROJ-10"`, "done")})
+	if counts := gatherMetrics(t, registry, "jira_epic_child_count"); len(counts) != 0 {
+		t.Errorf("jira_epic_child_count = %v without JIRA_EPIC_FIELD, want no series", counts)
+	}
+}
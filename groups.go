@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// metricGroup is a named group of issue metrics computed from the issues of its own JQL query instead of
+// the projects, fetched independently on each instance
+type metricGroup struct {
+	name string
+	jql  string
+	// metrics is the names of the issue metrics of the group, each in a single group at most
+	metrics []string
+	// countLabels is the labels of jira_issue_count of the group
+	countLabels []string
+}
+
+// loadMetricGroups loads the metric groups listed in METRIC_GROUPS. Each group is configured with the env vars
+// suffixed with its upper-cased name, e.g. METRIC_GROUP_JQL_BUGS and METRIC_GROUP_METRICS_BUGS for the group "bugs".
+func loadMetricGroups(cfg config) ([]metricGroup, error) {
+	groups := make([]metricGroup, 0)
+	for _, name := range parseList(getEnvOrDefault("METRIC_GROUPS", "")) {
+		suffix := envSuffix(name)
+		group := metricGroup{
+			name:    name,
+			jql:     getEnvOrDefault("METRIC_GROUP_JQL"+suffix, ""),
+			metrics: parseList(getEnvOrDefault("METRIC_GROUP_METRICS"+suffix, "")),
+		}
+		if group.jql == "" {
+			return nil, fmt.Errorf("METRIC_GROUP_JQL%s of the metric group %s is empty", suffix, name)
+		}
+		if len(group.metrics) == 0 {
+			return nil, fmt.Errorf("METRIC_GROUP_METRICS%s of the metric group %s is empty", suffix, name)
+		}
+		var err error
+		if group.countLabels, err = parseCountLabels(getEnvOrDefault("METRIC_GROUP_COUNT_LABELS"+suffix, ""), cfg); err != nil {
+			return nil, fmt.Errorf("metric group %s: %w", name, err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// envSuffix returns the suffix of the env vars of the named instance or group, e.g. "_LEGACY_ORG" for "legacy-org"
+func envSuffix(name string) string {
+	return "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// checkMetricGroups checks that the metric groups list only the known issue metrics, each in a single group
+func checkMetricGroups(cfg config, named []namedCollector) error {
+	grouped := make(map[string]string)
+	for _, group := range cfg.metricGroups {
+		for _, metric := range group.metrics {
+			if !slices.ContainsFunc(named, func(n namedCollector) bool { return n.name == metric }) {
+				return fmt.Errorf("unknown issue metric %q in the metric group %s", metric, group.name)
+			}
+			if other, ok := grouped[metric]; ok {
+				return fmt.Errorf("metric %q is in both metric groups %s and %s", metric, other, group.name)
+			}
+			grouped[metric] = group.name
+		}
+	}
+	return nil
+}
+
+// groupConfig returns the config of the group's metrics on the instance
+func groupConfig(cfg config, group metricGroup) config {
+	cfg.countLabels = group.countLabels
+	// ENABLED_METRICS and DISABLED_METRICS still apply to the group's metrics
+	disabled := slices.Clone(cfg.disabledMetrics)
+	for _, metric := range group.metrics {
+		if !isMetricEnabled(cfg, metric) {
+			disabled = append(disabled, metric)
+		}
+	}
+	cfg.enabledMetrics = group.metrics
+	cfg.disabledMetrics = disabled
+	return cfg
+}
+
+// ungroupedConfig returns the config of the metrics computed from the issues of the projects, without the ones
+// moved to the metric groups
+func ungroupedConfig(cfg config) config {
+	cfg.disabledMetrics = slices.Clone(cfg.disabledMetrics)
+	for _, group := range cfg.metricGroups {
+		cfg.disabledMetrics = append(cfg.disabledMetrics, group.metrics...)
+	}
+	return cfg
+}
+
+// fetchMetricGroups fetches the issues of each metric group of the instance by the group name. A group query
+// stopped at MAX_ISSUES sets jira_exporter_issue_limit_hit of the instance, as the projects fetched before do.
+func fetchMetricGroups(ctx context.Context, cfg config) (map[string][]JiraIssue, error) {
+	fetched := make(map[string][]JiraIssue, len(cfg.metricGroups))
+	for _, group := range cfg.metricGroups {
+		issues, truncated, err := fetchByJQL(ctx, cfg, group.jql, cfg.maxIssues)
+		if err != nil {
+			return nil, fmt.Errorf("metric group %s: %w", group.name, err)
+		}
+		if truncated {
+			fmt.Printf("Warning: the fetch of the metric group %s from %s stopped at MAX_ISSUES=%d issues, the metrics are incomplete\n",
+				group.name, cfg.jiraURL, cfg.maxIssues)
+			jiraExporterIssueLimitHit.WithLabelValues(instanceLabelValues(cfg)...).Set(1)
+		}
+		if fetched[group.name], err = completeIssues(ctx, cfg, issues); err != nil {
+			return nil, fmt.Errorf("metric group %s: %w", group.name, err)
+		}
+	}
+	return fetched, nil
+}
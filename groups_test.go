@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMetricGroups(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/api/3/status" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		issue := func(key, issueType, priority string) string {
+			return fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+				"status": {"name": "Done"}, "project": {"key": "PROJ"}, "issuetype": {"name": %q}, "priority": {"name": %q}},
+				"changelog": {"histories": [{"created": "2024-01-02T10:00:00.000+0000",
+					"items": [{"field": "status", "fromString": "Open", "toString": "Done"}]}]}}`, key, issueType, priority)
+		}
+		switch jql := r.URL.Query().Get("jql"); {
+		case jql == "type = Bug":
+			fmt.Fprintf(w, `{"issues": [%s, %s]}`, issue("PROJ-1", "Bug", "High"), issue("PROJ-2", "Bug", "Low"))
+		case jql == "type = Story":
+			fmt.Fprintf(w, `{"issues": [%s]}`, issue("PROJ-3", "Story", "High"))
+		case strings.Contains(jql, "project in (PROJ)"):
+			fmt.Fprintf(w, `{"issues": [%s, %s, %s]}`, issue("PROJ-1", "Bug", "High"), issue("PROJ-3", "Story", "High"),
+				issue("PROJ-4", "Task", "High"))
+		default:
+			t.Errorf("unexpected JQL %q", jql)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer jira.Close()
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	t.Setenv("METRIC_GROUPS", "bugs,story-flow")
+	t.Setenv("METRIC_GROUP_JQL_BUGS", "type = Bug")
+	t.Setenv("METRIC_GROUP_METRICS_BUGS", "jira_issue_count")
+	t.Setenv("METRIC_GROUP_COUNT_LABELS_BUGS", "priority")
+	t.Setenv("METRIC_GROUP_JQL_STORY_FLOW", "type = Story")
+	t.Setenv("METRIC_GROUP_METRICS_STORY_FLOW", "jira_issue_time_in_status")
+	cfg := testConfig(t)
+	var err error
+	if cfg.metricGroups, err = loadMetricGroups(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The bugs group counts its bugs by its own labels
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if len(counts) != 2 {
+		t.Errorf("jira_issue_count has %d series, want 2 of the bugs", len(counts))
+	}
+	for _, priority := range []string{"High", "Low"} {
+		metric := findMetric(counts, map[string]string{"priority": priority})
+		if metric.GetGauge().GetValue() != 1 || len(metric.GetLabel()) != 1 {
+			t.Errorf("jira_issue_count{priority=%q} = %v, want 1 with only the priority label", priority, metric)
+		}
+	}
+	// The story-flow group observes only its story
+	times := gatherMetrics(t, registry, "jira_issue_time_in_status")
+	if len(times) != 1 || findMetric(times, map[string]string{"issueType": "Story"}).GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("jira_issue_time_in_status = %v, want the single story", times)
+	}
+	// The other metrics are still computed from the issues of the projects
+	categories := gatherMetrics(t, registry, "jira_issue_status_category_count")
+	if len(categories) != 1 || categories[0].GetGauge().GetValue() != 3 {
+		t.Errorf("jira_issue_status_category_count = %v, want the 3 issues of the projects", categories)
+	}
+}
+
+func TestMetricGroupIssueLimit(t *testing.T) {
+	var bugs atomic.Int32
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/api/3/status" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		if r.URL.Query().Get("jql") != "type = Bug" {
+			fmt.Fprintf(w, `{"issues": [%s], "total": 1}`, testIssueJSON("PROJ-1"))
+			return
+		}
+		issues := make([]string, 0)
+		for i := 0; i < int(bugs.Load()); i++ {
+			issues = append(issues, testIssueJSON(fmt.Sprintf("BUG-%d", i)))
+		}
+		fmt.Fprintf(w, `{"issues": [%s], "total": %d}`, strings.Join(issues, ","), len(issues))
+	}))
+	defer jira.Close()
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	t.Setenv("METRIC_GROUPS", "bugs")
+	t.Setenv("METRIC_GROUP_JQL_BUGS", "type = Bug")
+	t.Setenv("METRIC_GROUP_METRICS_BUGS", "jira_issue_count")
+	cfg := testConfig(t)
+	cfg.maxIssues = 2
+	var err error
+	if cfg.metricGroups, err = loadMetricGroups(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	for _, tt := range []struct {
+		bugs int32
+		hit  float64
+	}{
+		{3, 1},
+		// Exactly MAX_ISSUES issues aren't cut off
+		{2, 0},
+	} {
+		bugs.Store(tt.bugs)
+		if _, err := refresh(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+		if hit := gatherMetrics(t, registry, "jira_exporter_issue_limit_hit"); len(hit) != 1 || hit[0].GetGauge().GetValue() != tt.hit {
+			t.Errorf("jira_exporter_issue_limit_hit with %d bugs = %v, want %v", tt.bugs, hit, tt.hit)
+		}
+		counts := gatherMetrics(t, registry, "jira_issue_count")
+		if len(counts) != 1 || counts[0].GetGauge().GetValue() != 2 {
+			t.Errorf("jira_issue_count with %d bugs = %v, want the 2 bugs within MAX_ISSUES", tt.bugs, counts)
+		}
+	}
+}
+
+func TestCheckMetricGroups(t *testing.T) {
+	named := NewCollector(testConfig(t)).namedCollectors()
+	for _, tt := range []struct {
+		groups []metricGroup
+		valid  bool
+	}{
+		{[]metricGroup{{name: "a", metrics: []string{"jira_issue_count"}}, {name: "b", metrics: []string{"jira_issue_time_in_status"}}}, true},
+		{[]metricGroup{{name: "a", metrics: []string{"jira_fetch_errors_total"}}}, false},
+		{[]metricGroup{{name: "a", metrics: []string{"jira_issue_count"}}, {name: "b", metrics: []string{"jira_issue_count"}}}, false},
+	} {
+		cfg := config{metricGroups: tt.groups}
+		if err := checkMetricGroups(cfg, named); (err == nil) != tt.valid {
+			t.Errorf("checkMetricGroups(%v) = %v, want valid %v", tt.groups, err, tt.valid)
+		}
+	}
+
+	t.Setenv("METRIC_GROUPS", "bugs")
+	t.Setenv("METRIC_GROUP_METRICS_BUGS", "jira_issue_count")
+	if _, err := loadMetricGroups(testConfig(t)); err == nil {
+		t.Error("loadMetricGroups() of a group without JQL succeeded, want an error")
+	}
+}
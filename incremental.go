@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// issueCache retains the fetched issues between refreshes for the incremental fetching
+type issueCache struct {
+	issues map[string]JiraIssue
+	// watermark is the latest update time among the retained issues
+	watermark time.Time
+	lastFull  time.Time
+}
+
+// refresh fetches the issues updated since the watermark and merges them into the retained ones. It makes a full
+// fetch on the first call, every cfg.fullRefreshInterval, and always when the incremental fetching is disabled.
+func (c *issueCache) refresh(ctx context.Context, cfg config, now time.Time) ([]JiraIssue, error) {
+	if cfg.fullRefreshInterval == 0 {
+		return fetchJiraData(ctx, cfg)
+	}
+	if c.issues == nil || now.Sub(c.lastFull) >= cfg.fullRefreshInterval {
+		issues, err := fetchJiraData(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.issues = make(map[string]JiraIssue, len(issues))
+		c.watermark = time.Time{}
+		c.lastFull = now
+		c.merge(issues)
+		return c.list(), nil
+	}
+	issues, err := fetchUpdatedSince(ctx, cfg, c.watermark, now)
+	if err != nil {
+		return nil, err
+	}
+	c.merge(issues)
+	c.evict(cfg, now)
+	return c.list(), nil
+}
+
+// merge replaces the retained issues with the fetched ones and advances the watermark
+func (c *issueCache) merge(issues []JiraIssue) {
+	for _, issue := range issues {
+		c.issues[issue.Key] = issue
+		if updated, _ := parseJiraTime(issue.Fields.Updated); updated.After(c.watermark) {
+			c.watermark = updated
+		}
+	}
+}
+
+// evict removes the issues with the window field before the start of their project's analyze window
+func (c *issueCache) evict(cfg config, now time.Time) {
+	for key, issue := range c.issues {
+		// An issue with an invalid or empty time is evicted, the next full refresh brings it back if it's still in the window
+		if at, _ := parseJiraTime(windowFieldTime(cfg, issue)); at.Before(windowStart(cfg, issue.Fields.Project.Key, now)) {
+			delete(c.issues, key)
+		}
+	}
+}
+
+// list returns the retained issues sorted by key
+func (c *issueCache) list() []JiraIssue {
+	issues := make([]JiraIssue, 0, len(c.issues))
+	for _, issue := range c.issues {
+		issues = append(issues, issue)
+	}
+	slices.SortFunc(issues, func(a, b JiraIssue) int { return strings.Compare(a.Key, b.Key) })
+	return issues
+}
+
+// fetchUpdatedSince fetches the issues of all projects updated since the given time
+func fetchUpdatedSince(ctx context.Context, cfg config, since, now time.Time) ([]JiraIssue, error) {
+	return fetchProjects(ctx, cfg, func(window projectWindow) string {
+		return buildUpdatedSinceJQL(cfg, window, since, now)
+	})
+}
+
+// updatedSinceOverlap is added to the incremental window to cover the clock skew between the exporter and Jira.
+// The issues fetched again are merged idempotently.
+const updatedSinceOverlap = 2 * time.Minute
+
+// buildUpdatedSinceJQL returns the JQL query for the issues of the window updated since the given time.
+// The time is sent as a relative date in minutes, which unlike an absolute date doesn't depend on the time zone
+// of the Jira user. Without a watermark, e.g. when the last full fetch found no issues, the whole window is fetched.
+// A window based on another field than updated, or a saved filter, is kept as an additional condition.
+func buildUpdatedSinceJQL(cfg config, window projectWindow, since, now time.Time) string {
+	if since.IsZero() {
+		return buildJQL(cfg, window, now)
+	}
+	minutes := (now.Sub(since) + updatedSinceOverlap + time.Minute - 1) / time.Minute
+	if cfg.windowField != "updated" || cfg.filterID != "" {
+		return fmt.Sprintf("updated >= -%dm AND %s", minutes, buildJQL(cfg, window, now))
+	}
+	return fmt.Sprintf("updated >= -%dm AND project in (%s)", minutes, strings.Join(window.projects, ","))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildUpdatedSinceJQL(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		since time.Time
+		want  string
+	}{
+		{"minutes with the overlap", now.Add(-10 * time.Minute), "updated >= -12m AND project in (PROJ)"},
+		{"rounded up", now.Add(-10*time.Minute - time.Second), "updated >= -13m AND project in (PROJ)"},
+		{"no watermark", time.Time{}, "updated >= -90d AND project in (PROJ)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildUpdatedSinceJQL(cfg, cfg.projects[0], tt.since, now); got != tt.want {
+				t.Errorf("buildUpdatedSinceJQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildUpdatedSinceJQLWithWindowField(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.windowField = "created"
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	want := "updated >= -12m AND created >= -90d AND project in (PROJ)"
+	if got := buildUpdatedSinceJQL(cfg, cfg.projects[0], now.Add(-10*time.Minute), now); got != want {
+		t.Errorf("buildUpdatedSinceJQL() = %q, want %q", got, want)
+	}
+	if _, err := parseFields("created,updated,status,project,issuetype", config{windowField: "resolved", fullRefreshInterval: time.Hour}); err == nil {
+		t.Error("parseFields() without resolutiondate succeeded, want an error with the resolved window and the incremental fetching")
+	}
+}
+
+func TestIssueCacheRefresh(t *testing.T) {
+	// Jira times have millisecond precision
+	now := time.Now().Truncate(time.Millisecond)
+	issueJSON := func(key, status string, updated time.Time) string {
+		return fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000", "updated": %q,
+			"status": {"name": %q}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+			key, updated.Format(jiraTimeFormat), status)
+	}
+	var jqls []string
+	responses := []string{
+		// The full fetch
+		issueJSON("PROJ-1", "Open", now.Add(-time.Hour)) + "," + issueJSON("PROJ-2", "Open", now.Add(-89*day)),
+		// The incremental fetch
+		issueJSON("PROJ-1", "Done", now.Add(time.Hour)) + "," + issueJSON("PROJ-3", "Open", now.Add(time.Hour)),
+	}
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		jqls = append(jqls, r.URL.Query().Get("jql"))
+		fmt.Fprintf(w, `{"issues": [%s]}`, responses[0])
+		responses = responses[1:]
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.fullRefreshInterval = 7 * day
+	registerTestMetrics(t, cfg)
+	cache := &issueCache{}
+
+	if _, err := cache.refresh(context.Background(), cfg, now); err != nil {
+		t.Fatal(err)
+	}
+	// Two days later, PROJ-2 falls out of the window
+	issues, err := cache.refresh(context.Background(), cfg, now.Add(2*day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(jqls[1], fmt.Sprintf("updated >= -%dm ", (2*day+time.Hour+updatedSinceOverlap)/time.Minute)) {
+		t.Errorf("incremental JQL = %q, want the issues updated since the watermark", jqls[1])
+	}
+	got := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		got = append(got, issue.Key+":"+issue.Fields.Status.Name)
+	}
+	if want := []string{"PROJ-1:Done", "PROJ-3:Open"}; !slices.Equal(got, want) {
+		t.Errorf("issues = %q, want %q", got, want)
+	}
+}
+
+func TestIssueCacheRefreshWithoutIssues(t *testing.T) {
+	var jqls []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jqls = append(jqls, r.URL.Query().Get("jql"))
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.fullRefreshInterval = time.Hour
+	registerTestMetrics(t, cfg)
+	cache := &issueCache{}
+
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(time.Minute)} {
+		if _, err := cache.refresh(context.Background(), cfg, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "updated >= -90d AND project in (PROJ)"
+	if len(jqls) != 2 || jqls[1] != want {
+		t.Errorf("JQLs = %q, want the incremental fetch without a watermark to use %q", jqls, want)
+	}
+}
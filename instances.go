@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/time/rate"
+)
+
+// loadInstances loads the Jira instances to fetch from. Without JIRA_INSTANCES, there is a single unnamed
+// instance configured with JIRA_URL, JIRA_USER, etc. Otherwise, each named instance is configured with
+// the same env vars suffixed with its upper-cased name, e.g. JIRA_URL_CLOUD for the instance "cloud".
+func loadInstances(cfg config, maxAnalyzePeriod time.Duration) ([]config, error) {
+	names := parseList(getEnvOrDefault("JIRA_INSTANCES", ""))
+	if len(names) == 0 {
+		instance, err := loadInstance(cfg, "", maxAnalyzePeriod)
+		return []config{instance}, err
+	}
+	instances := make([]config, 0, len(names))
+	for _, name := range names {
+		instance, err := loadInstance(cfg, name, maxAnalyzePeriod)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// loadInstance copies the shared config and sets the URL, the credentials and the projects of the instance
+func loadInstance(cfg config, name string, maxAnalyzePeriod time.Duration) (config, error) {
+	suffix := ""
+	if name != "" {
+		suffix = envSuffix(name)
+	}
+	instance := cfg
+	instance.instance = name
+	instance.jiraURL = getEnvOrDie("JIRA_URL" + suffix)
+	instance.client = &http.Client{Transport: cfg.transport}
+	instance.statusCategories = &statusCategories{}
+	instance.cache = &issueCache{}
+	if tokenURL := getEnvOrDefault("OAUTH_TOKEN_URL"+suffix, ""); tokenURL != "" {
+		instance.oauth = &clientcredentials.Config{
+			TokenURL:     tokenURL,
+			ClientID:     getEnvOrDie("OAUTH_CLIENT_ID" + suffix),
+			ClientSecret: getEnvOrDie("OAUTH_CLIENT_SECRET" + suffix),
+		}
+		if scopes := getEnvOrDefault("OAUTH_SCOPES"+suffix, ""); scopes != "" {
+			instance.oauth.Scopes = strings.Split(scopes, ",")
+		}
+		// The client caches the token and refreshes it before expiry, and sends the requests with the shared transport
+		instance.client = instance.oauth.Client(context.WithValue(context.Background(), oauth2.HTTPClient, instance.client))
+	} else {
+		instance.jiraUser = getEnvOrDie("JIRA_USER" + suffix)
+		instance.jiraAPIToken = getEnvOrDie("JIRA_API_TOKEN" + suffix)
+	}
+	instance.client.Timeout = cfg.requestTimeout
+	instance.client.CheckRedirect = checkRedirect
+	if cfg.jiraRPS > 0 {
+		instance.limiter = rate.NewLimiter(rate.Limit(cfg.jiraRPS), 1)
+	}
+	// A saved filter replaces the projects and the analyze window
+	projects := getEnvOrDefault("JIRA_PROJECTS"+suffix, "")
+	instance.filterID = getEnvOrDefault("JIRA_FILTER_ID"+suffix, "")
+	if instance.filterID != "" {
+		if projects != "" {
+			return config{}, fmt.Errorf("JIRA_FILTER_ID%s and JIRA_PROJECTS%s are mutually exclusive", suffix, suffix)
+		}
+		if _, err := strconv.ParseUint(instance.filterID, 10, 64); err != nil {
+			return config{}, fmt.Errorf("invalid JIRA_FILTER_ID%s %q, must be a numeric filter ID", suffix, instance.filterID)
+		}
+		return instance, nil
+	}
+	if projects == "" {
+		return config{}, fmt.Errorf("either JIRA_PROJECTS%s or JIRA_FILTER_ID%s must be set", suffix, suffix)
+	}
+	var err error
+	instance.projects, err = parseProjects(projects, cfg.analyzePeriod)
+	if err != nil {
+		return config{}, err
+	}
+	instance.windowClamped = clampPeriods(instance.projects, maxAnalyzePeriod)
+	return instance, nil
+}
+
+// findInstance returns the config of the named instance, or the first instance if name is empty
+func findInstance(cfg config, name string) (config, bool) {
+	if name == "" {
+		return cfg.instances[0], true
+	}
+	for _, instance := range cfg.instances {
+		if instance.instance == name {
+			return instance, true
+		}
+	}
+	return config{}, false
+}
+
+// multiInstance reports whether the instances are named, so the metrics get the instance label
+func multiInstance(cfg config) bool {
+	return len(cfg.instances) > 0 && cfg.instances[0].instance != ""
+}
+
+// instanceLabelNames prepends the instance label to the label names with multiple instances
+func instanceLabelNames(cfg config, names ...string) []string {
+	if !multiInstance(cfg) {
+		return names
+	}
+	return append([]string{"instance"}, names...)
+}
+
+// instanceLabelValues prepends the instance name to the label values of a named instance
+func instanceLabelValues(cfg config, values ...string) []string {
+	if cfg.instance == "" {
+		return values
+	}
+	return append([]string{cfg.instance}, values...)
+}
+
+// withInstanceLabel adds the instance label to the labels of a named instance
+func withInstanceLabel(cfg config, labels prometheus.Labels) prometheus.Labels {
+	if cfg.instance != "" {
+		labels["instance"] = cfg.instance
+	}
+	return labels
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newInstanceServer mocks a Jira instance with a single issue of the project, accepting only the user's credentials
+func newInstanceServer(t *testing.T, user, token, project string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != user || p != token {
+			t.Errorf("%s: credentials %s:%s, want %s:%s", project, u, p, user, token)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/rest/api/3/status":
+			fmt.Fprint(w, `[]`)
+		case r.URL.Query().Get("startAt") == "0":
+			fmt.Fprintf(w, `{"issues": [{"key": "%s-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+				"status": {"name": "Open"}, "project": {"key": %q}, "issuetype": {"name": "Task"}}}]}`, project, project)
+		default:
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMultipleInstances(t *testing.T) {
+	cloud := newInstanceServer(t, "cloud-user", "cloud-token", "CLOUD")
+	legacy := newInstanceServer(t, "legacy-user", "legacy-token", "LEGACY")
+	t.Setenv("JIRA_INSTANCES", "cloud,legacy-org")
+	t.Setenv("JIRA_URL_CLOUD", cloud.URL)
+	t.Setenv("JIRA_USER_CLOUD", "cloud-user")
+	t.Setenv("JIRA_API_TOKEN_CLOUD", "cloud-token")
+	t.Setenv("JIRA_PROJECTS_CLOUD", "CLOUD")
+	t.Setenv("JIRA_URL_LEGACY_ORG", legacy.URL)
+	t.Setenv("JIRA_USER_LEGACY_ORG", "legacy-user")
+	t.Setenv("JIRA_API_TOKEN_LEGACY_ORG", "legacy-token")
+	t.Setenv("JIRA_PROJECTS_LEGACY_ORG", "LEGACY:30")
+	cfg := testConfig(t)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if len(counts) != 2 {
+		t.Errorf("jira_issue_count has %d series, want 2", len(counts))
+	}
+	for instance, project := range map[string]string{"cloud": "CLOUD", "legacy-org": "LEGACY"} {
+		if metric := findMetric(counts, map[string]string{"instance": instance, "project": project}); metric.GetGauge().GetValue() != 1 {
+			t.Errorf("jira_issue_count{instance=%q,project=%q} = %v, want 1", instance, project, metric.GetGauge().GetValue())
+		}
+	}
+}
+
+func TestSingleInstanceWithoutLabel(t *testing.T) {
+	jira := newInstanceServer(t, "user", "token", "PROJ")
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	cfg := testConfig(t)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if len(counts) != 1 {
+		t.Fatalf("jira_issue_count has %d series, want 1", len(counts))
+	}
+	for _, pair := range counts[0].GetLabel() {
+		if pair.GetName() == "instance" {
+			t.Errorf("the single instance has the instance label %q", pair.GetValue())
+		}
+	}
+}
+
+func TestFilterID(t *testing.T) {
+	var jqls []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jqls = append(jqls, r.URL.Query().Get("jql"))
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "")
+	t.Setenv("JIRA_FILTER_ID", "12345")
+	cfg := testConfig(t)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg.instances[0]); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"filter = 12345"}; !slices.Equal(jqls, want) {
+		t.Errorf("JQLs = %q, want %q", jqls, want)
+	}
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	want := "updated >= -12m AND filter = 12345"
+	if got := buildUpdatedSinceJQL(cfg.instances[0], projectWindow{}, now.Add(-10*time.Minute), now); got != want {
+		t.Errorf("buildUpdatedSinceJQL() = %q, want %q", got, want)
+	}
+}
+
+func TestFilterIDErrors(t *testing.T) {
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", "https://jira.example.com")
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	for _, tt := range []struct{ projects, filterID string }{
+		{"PROJ", "12345"},
+		{"", "my-filter"},
+		{"", ""},
+	} {
+		t.Setenv("JIRA_PROJECTS", tt.projects)
+		t.Setenv("JIRA_FILTER_ID", tt.filterID)
+		if _, err := loadInstances(testConfig(t), 365*day); err == nil {
+			t.Errorf("loadInstances() with JIRA_PROJECTS=%q and JIRA_FILTER_ID=%q succeeded, want an error", tt.projects, tt.filterID)
+		}
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	var requests, connections atomic.Int32
+	jira := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/rest/api/3/status" {
+			fmt.Fprintln(w, `[]`)
+			return
+		}
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprintln(w, `{"issues": []}`)
+			return
+		}
+		fmt.Fprintln(w, `{"issues": [{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}]}`)
+	}))
+	jira.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	jira.Start()
+	defer jira.Close()
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	cfg := testConfig(t)
+	cfg.transport = newTransport(100, 10, time.Minute)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registerTestMetrics(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		if _, err := refresh(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests.Load() < 6 {
+		t.Fatalf("%d requests, want at least 2 per refresh", requests.Load())
+	}
+	if connections.Load() != 1 {
+		t.Errorf("%d requests opened %d connections, want 1", requests.Load(), connections.Load())
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("followed the redirect to another host: %s", r.URL)
+	}))
+	defer other.Close()
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/old/"):
+			http.Redirect(w, r, "/jira/"+strings.TrimPrefix(r.URL.RequestURI(), "/old/"), http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, "/jira/"):
+			http.Redirect(w, r, "/"+strings.TrimPrefix(r.URL.RequestURI(), "/jira/"), http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/moved/"):
+			http.Redirect(w, r, other.URL+r.URL.RequestURI(), http.StatusFound)
+		default:
+			if u, p, _ := r.BasicAuth(); u != "user" || p != "token" {
+				t.Errorf("credentials %s:%s after the redirects, want user:token", u, p)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer jira.Close()
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	load := func(jiraURL string) config {
+		t.Setenv("JIRA_URL", jiraURL)
+		instances, err := loadInstances(testConfig(t), 365*day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registerTestMetrics(t, instances[0])
+		return instances[0]
+	}
+
+	if _, err := fetchJiraData(context.Background(), load(jira.URL+"/old")); err != nil {
+		t.Errorf("fetchJiraData() with the same-host redirects: %v", err)
+	}
+	_, err := fetchJiraData(context.Background(), load(jira.URL+"/moved"))
+	if err == nil || !strings.Contains(err.Error(), "refused redirect") {
+		t.Errorf("fetchJiraData() with the cross-host redirect = %v, want the refused redirect", err)
+	}
+}
+
+func TestChangelogProcessingDuration(t *testing.T) {
+	jira := newInstanceServer(t, "user", "token", "PROJ")
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ")
+	cfg := testConfig(t)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	// The processing time may round to zero, so a refresh must overwrite an impossible value
+	jiraExporterChangelogProcessingDuration.Set(-1)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	metrics := gatherMetrics(t, registry, "jira_exporter_changelog_processing_duration_seconds")
+	if len(metrics) != 1 || metrics[0].GetGauge().GetValue() < 0 {
+		t.Errorf("jira_exporter_changelog_processing_duration_seconds = %v after a refresh, want the processing time", metrics)
+	}
+}
+
+func TestProjectCounts(t *testing.T) {
+	jira := newInstanceServer(t, "user", "token", "PROJ")
+	t.Setenv("JIRA_INSTANCES", "")
+	t.Setenv("JIRA_URL", jira.URL)
+	t.Setenv("JIRA_USER", "user")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_PROJECTS", "PROJ,EMPTY")
+	cfg := testConfig(t)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{"jira_exporter_configured_projects": 2, "jira_exporter_returned_projects": 1} {
+		if metrics := gatherMetrics(t, registry, name); len(metrics) != 1 || metrics[0].GetGauge().GetValue() != want {
+			t.Errorf("%s = %v, want %v", name, metrics, want)
+		}
+	}
+}
+
+func TestGlobalFetchConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for max := maxInFlight.Load(); n > max && !maxInFlight.CompareAndSwap(max, n); max = maxInFlight.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/rest/api/3/status" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	t.Cleanup(jira.Close)
+	names := []string{"a", "b", "c", "d"}
+	t.Setenv("JIRA_INSTANCES", strings.Join(names, ","))
+	for _, name := range names {
+		suffix := "_" + strings.ToUpper(name)
+		t.Setenv("JIRA_URL"+suffix, jira.URL)
+		t.Setenv("JIRA_USER"+suffix, "user")
+		t.Setenv("JIRA_API_TOKEN"+suffix, "token")
+		t.Setenv("JIRA_PROJECTS"+suffix, "ONE,TWO,THREE")
+	}
+	cfg := testConfig(t)
+	cfg.fetchSlots = make(chan struct{}, 2)
+	var err error
+	if cfg.instances, err = loadInstances(cfg, 365*day); err != nil {
+		t.Fatal(err)
+	}
+	registerTestMetrics(t, cfg)
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("%d simultaneous requests to the instances, want the limit of 2", got)
+	}
+	if len(cfg.fetchSlots) != 0 {
+		t.Errorf("%d fetch slots are still held after the refresh, want 0", len(cfg.fetchSlots))
+	}
+}
@@ -1,17 +1,33 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	// Embed the time zone database, the release image doesn't have one
+	_ "time/tzdata"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -21,121 +37,825 @@ const (
 type config struct {
 	listen            string
 	dataRefreshPeriod time.Duration
-	jiraURL           string
-	jiraUser          string
-	jiraAPIToken      string
-	projects          string
-	analyzePeriod     string
+	// refreshJitter is the fraction of dataRefreshPeriod to randomly shift the refreshes by
+	refreshJitter    float64
+	jiraURL          string
+	jiraUser         string
+	jiraAPIToken     string
+	oauth            *clientcredentials.Config
+	client           *http.Client
+	requestTimeout   time.Duration
+	statusCategories *statusCategories
+	fields           []string
+	userAgent        string
+	projects         []projectWindow
+	analyzePeriod    string
+	// filterID is the ID of the saved filter defining the scope instead of the projects, empty if not used
+	filterID          string
+	windowField       string
+	readinessMaxAge   time.Duration
+	readinessCacheTTL time.Duration
+	storyPointsField  string
+	sprintField       string
+	epicField         string
+	countLabels       []string
+	// assigneeLabelSource is the assignee field of the assignee labels: email, accountId or displayName
+	assigneeLabelSource string
+	// reporterLabelSource is the reporter field of the reporter labels, as assigneeLabelSource
+	reporterLabelSource string
+	includeIssueTypes   []string
+	excludeIssueTypes   []string
+	// trackFields are the changelog fields whose changes are counted, as named in the changelog
+	trackFields      []string
+	location         *time.Location
+	businessCalendar *businessCalendar
+	windowClamped    bool
+	// fullRefreshInterval enables the incremental fetching with a full refresh at this interval
+	fullRefreshInterval time.Duration
+	dryRun              bool
+	enableExemplars     bool
+	enableStatusSummary bool
+	metricNamespace     string
+	metricSubsystem     string
+	// tokenPagination selects the enhanced search API paginated with nextPageToken
+	tokenPagination bool
+	// debugEndpointsEnabled enables the /debug/* endpoints
+	debugEndpointsEnabled bool
+	// refreshEndpointEnabled enables POST /refresh
+	refreshEndpointEnabled bool
+	// httpPathPrefix is the path prefix of all endpoints on the listener, e.g. /jira, or empty
+	httpPathPrefix string
+	// initialScrapeTimeout bounds the first refresh, 0 if unbounded
+	initialScrapeTimeout time.Duration
+	// slaThresholds is the max allowed time in the status by status name
+	slaThresholds map[string]time.Duration
+	// issueTypeBuckets is the time in status histogram buckets in seconds overridden by issue type
+	issueTypeBuckets map[string][]float64
+	// transport is shared by the clients of all instances, nil for the default transport
+	transport http.RoundTripper
+	// scrapeMode is poll to refresh in the background, or pull to refresh on the scrapes
+	scrapeMode string
+	// maxIssues is the max number of issues fetched from the instance at once, 0 if unlimited
+	maxIssues int
+	// ignoreStatuses is the statuses not observed in the time in status metrics
+	ignoreStatuses []string
+	// openAgeBuckets is the increasing upper bounds of the open issue age brackets
+	openAgeBuckets []time.Duration
+	// cycleTimeStartStatuses is the statuses whose first entry starts the cycle time
+	cycleTimeStartStatuses []string
+	// blockedStatuses is the statuses of the blocked issues
+	blockedStatuses []string
+	// excludeResolvedBefore is the age of the resolution dates of the issues excluded from the metrics, 0 to keep all
+	excludeResolvedBefore time.Duration
+	// changelogExpandWindow is the age of the update times of the issues whose changelogs are fetched, 0 to fetch
+	// the changelogs of all issues with the search
+	changelogExpandWindow time.Duration
+	// logSampleEveryNPages is the sampling rate of the per-page fetch logs, 1 to log every page
+	logSampleEveryNPages int
+	// readinessFailureThreshold is the number of consecutive failed refreshes that makes the exporter not ready
+	readinessFailureThreshold int
+	// enabledMetrics is the names of the registered metrics, empty for all
+	enabledMetrics []string
+	// disabledMetrics is the names of the metrics not registered
+	disabledMetrics []string
+	// clock is the time source of the windows and the ages, nil for the real time
+	clock clock
+	// staticLabels is the constant labels of all metrics, e.g. env=prod
+	staticLabels prometheus.Labels
+	// pprofListen is the address of the pprof listener, empty if pprof is disabled
+	pprofListen string
+	// snapshotPath is the file the issues of the last successful refresh are persisted to, empty if disabled
+	snapshotPath string
+	// jiraRPS limits the requests per second to each Jira instance, 0 for unlimited
+	jiraRPS float64
+	// limiter is shared by the copies of the instance config, nil if unlimited
+	limiter *rate.Limiter
+	// metricGroups is the groups of issue metrics with their own JQL queries
+	metricGroups []metricGroup
+	// fetchSlots bounds the simultaneous requests to all Jira instances, shared by all copies of the config,
+	// nil if unlimited
+	fetchSlots chan struct{}
+	// instance is the name of the Jira instance, empty with a single instance
+	instance string
+	// instances are the configs of the Jira instances, each a copy of this config with its own URL,
+	// credentials, projects and state
+	instances []config
+	cache     *issueCache
 }
 
-// fetchJiraData connects to the Jira API and fetches issues data
-func fetchJiraData(cfg config) ([]JiraIssue, error) {
+// clock tells the current time for the windows and the ages, so the tests can fix it
+type clock interface {
+	Now() time.Time
+}
+
+// now returns the time of the configured clock, or the real time without one
+func (cfg config) now() time.Time {
+	if cfg.clock == nil {
+		return time.Now()
+	}
+	return cfg.clock.Now()
+}
+
+// lastSuccessfulRefresh holds the time of the last successful data refresh in UnixNano
+var lastSuccessfulRefresh atomic.Int64
+
+// consecutiveRefreshFailures counts the failed refreshes since the last successful one
+var consecutiveRefreshFailures atomic.Int64
+
+// refreshMu serializes the scheduled and the manual refreshes, which share the issue caches and the metrics
+var refreshMu sync.Mutex
+
+// projectWindow is a group of projects fetched with the same analyze period
+type projectWindow struct {
+	projects []string
+	period   period
+}
+
+// parseProjects parses the projects spec like "PROJ1:30,PROJ2:startOfMonth,PROJ3" and groups
+// the projects by analyze period. Projects without an explicit period use defaultPeriod.
+func parseProjects(spec string, defaultPeriod string) ([]projectWindow, error) {
+	windows := make([]projectWindow, 0)
+	byPeriod := make(map[period]int)
+	for _, item := range strings.Split(spec, ",") {
+		project, analyzePeriod, found := strings.Cut(strings.TrimSpace(item), ":")
+		if project == "" {
+			continue
+		}
+		if !found {
+			analyzePeriod = defaultPeriod
+		}
+		p, err := getPeriod(analyzePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %w", project, err)
+		}
+		i, ok := byPeriod[p]
+		if !ok {
+			i = len(windows)
+			byPeriod[p] = i
+			windows = append(windows, projectWindow{period: p})
+		}
+		windows[i].projects = append(windows[i].projects, project)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no projects in %q", spec)
+	}
+	return windows, nil
+}
+
+// clampPeriods limits the relative analyze periods of the windows to maxPeriod. It returns true if any period was clamped.
+func clampPeriods(windows []projectWindow, maxPeriod time.Duration) bool {
+	clamped := false
+	for i := range windows {
+		if windows[i].period.function != "" || windows[i].period.duration <= maxPeriod {
+			continue
+		}
+		fmt.Printf("Warning: analyze period %s of projects %s exceeds the maximum, clamped to %s\n",
+			windows[i].period.jql(), strings.Join(windows[i].projects, ","), period{duration: maxPeriod}.jql())
+		windows[i].period.duration = maxPeriod
+		clamped = true
+	}
+	return clamped
+}
+
+// buildJQL returns the JQL query for the projects window at now. Jira would evaluate the start functions
+// in the time zone of its user, so they are sent as dates computed in the configured time zone instead.
+// With a saved filter, the filter defines the scope instead of the projects and the window.
+func buildJQL(cfg config, window projectWindow, now time.Time) string {
+	if cfg.filterID != "" {
+		return "filter = " + cfg.filterID
+	}
+	start := window.period.jql()
+	if window.period.function != "" {
+		start = window.period.start(localTime(cfg, now)).Format(`"2006/01/02 15:04"`)
+	}
+	return fmt.Sprintf("%s >= %s AND project in (%s)", cfg.windowField, start, strings.Join(window.projects, ","))
+}
+
+// windowFields maps the JQL fields the analyze window can be based on to the issue fields holding their values
+var windowFields = map[string]string{
+	"updated":  "updated",
+	"created":  "created",
+	"resolved": "resolutiondate",
+}
+
+// parseWindowField validates the JQL field of the analyze window
+func parseWindowField(field string) (string, error) {
+	if _, ok := windowFields[field]; !ok {
+		return "", fmt.Errorf("invalid JIRA_WINDOW_FIELD %q, must be updated, created or resolved", field)
+	}
+	return field, nil
+}
+
+// windowFieldTime returns the issue's value of the window field
+func windowFieldTime(cfg config, issue JiraIssue) string {
+	switch cfg.windowField {
+	case "created":
+		return issue.Fields.Created
+	case "resolved":
+		return issue.Fields.ResolutionDate
+	default:
+		return issue.Fields.Updated
+	}
+}
+
+// localTime returns t in the configured time zone
+func localTime(cfg config, t time.Time) time.Time {
+	if cfg.location == nil {
+		return t
+	}
+	return t.In(cfg.location)
+}
+
+// windowStart returns the start of the analyze window of the project, or the zero time for unknown projects.
+// Day boundaries of the start functions are taken in the configured time zone.
+func windowStart(cfg config, project string, now time.Time) time.Time {
+	now = localTime(cfg, now)
+	for _, window := range cfg.projects {
+		if slices.ContainsFunc(window.projects, func(p string) bool { return strings.EqualFold(p, project) }) {
+			return window.period.start(now)
+		}
+	}
+	return time.Time{}
+}
+
+// fetchJiraData connects to the Jira API and fetches issues data of all projects
+func fetchJiraData(ctx context.Context, cfg config) ([]JiraIssue, error) {
+	now := cfg.now()
+	return fetchProjects(ctx, cfg, func(window projectWindow) string {
+		return buildJQL(cfg, window, now)
+	})
+}
+
+// fetchProjects fetches the issues of each project with its own JQL query, removing the duplicates.
+// The projects that don't exist or aren't visible to the user are skipped, unless all of them are.
+// The fetch stops at MAX_ISSUES, exporting the issues fetched so far.
+func fetchProjects(ctx context.Context, cfg config, jql func(window projectWindow) string) ([]JiraIssue, error) {
 	issues := make([]JiraIssue, 0)
-	startAt := 0
-	for {
-		issuesChunk, err := fetchStartingFrom(cfg, startAt)
+	windows := queryWindows(cfg)
+	skipped := 0
+	limitHit := false
+	for _, window := range windows {
+		limit := 0
+		if cfg.maxIssues > 0 {
+			// The windows still pending aren't fetched
+			if len(issues) >= cfg.maxIssues {
+				limitHit = true
+				break
+			}
+			limit = cfg.maxIssues - len(issues)
+		}
+		windowIssues, truncated, err := fetchByJQL(ctx, cfg, jql(window), limit)
+		if len(window.projects) == 1 && isProjectError(err) {
+			fmt.Printf("Skipping project %s: %s\n", window.projects[0], err)
+			jiraExporterProjectErrors.WithLabelValues(instanceLabelValues(cfg, window.projects[0])...).Inc()
+			skipped++
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		issues = append(issues, windowIssues...)
+		limitHit = limitHit || truncated
+	}
+	if skipped > 0 && skipped == len(windows) {
+		return nil, fmt.Errorf("none of the projects is accessible")
+	}
+	if limitHit {
+		fmt.Printf("Warning: the fetch from %s stopped at MAX_ISSUES=%d issues, the metrics are incomplete\n", cfg.jiraURL, cfg.maxIssues)
+		jiraExporterIssueLimitHit.WithLabelValues(instanceLabelValues(cfg)...).Set(1)
+	} else {
+		jiraExporterIssueLimitHit.WithLabelValues(instanceLabelValues(cfg)...).Set(0)
+	}
+	return completeIssues(ctx, cfg, issues)
+}
+
+// completeIssues removes the duplicates of the fetched issues and fetches the changelogs missing in the search response
+func completeIssues(ctx context.Context, cfg config, issues []JiraIssue) ([]JiraIssue, error) {
+	issues = dedupIssues(issues)
+	if err := expandChangelogs(ctx, cfg, issues); err != nil {
+		return nil, err
+	}
+	if err := completeChangelogs(ctx, cfg, issues); err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+// queryWindows returns the windows queried separately: a window per project, or a single empty window
+// with a saved filter
+func queryWindows(cfg config) []projectWindow {
+	if cfg.filterID != "" {
+		return []projectWindow{{}}
+	}
+	return projectWindows(cfg.projects)
+}
+
+// projectWindows splits the windows into single-project ones, so a failing project doesn't fail the others
+func projectWindows(windows []projectWindow) []projectWindow {
+	split := make([]projectWindow, 0, len(windows))
+	for _, window := range windows {
+		for _, project := range window.projects {
+			split = append(split, projectWindow{projects: []string{project}, period: window.period})
+		}
+	}
+	return split
+}
+
+// isProjectError checks that Jira rejected the JQL query because the project doesn't exist or the user
+// has no permission to see it
+func isProjectError(err error) bool {
+	var apiErr *JiraAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(apiErr.Body, "does not exist")
+}
+
+// dedupIssues removes the issues with repeated keys, keeping the first occurrence
+func dedupIssues(issues []JiraIssue) []JiraIssue {
+	seen := make(map[string]bool, len(issues))
+	unique := issues[:0]
+	for _, issue := range issues {
+		if seen[issue.Key] {
+			continue
+		}
+		seen[issue.Key] = true
+		unique = append(unique, issue)
+	}
+	return unique
+}
+
+// fetchByJQL fetches the pages of the JQL query until the limit of issues, 0 for all pages. It also returns
+// whether the query has more issues than the limit, which were cut off.
+func fetchByJQL(ctx context.Context, cfg config, jql string, limit int) ([]JiraIssue, bool, error) {
+	if cfg.tokenPagination {
+		return fetchByJQLWithTokens(ctx, cfg, jql, limit)
+	}
+	issues := make([]JiraIssue, 0)
+	startAt := 0
+	total := -1
+	for page := 0; ; page++ {
+		issuesChunk, pageTotal, err := fetchStartingFrom(ctx, cfg, jql, startAt, page)
+		if err != nil {
+			return nil, false, err
+		}
+		total = pageTotal
 		if len(issuesChunk) == 0 {
 			break
 		}
 		issues = append(issues, issuesChunk...)
+		// Without the total, only the next page tells whether the query has more issues than the limit
+		if limit > 0 && len(issues) >= limit && (len(issues) > limit || total >= 0) {
+			return issues[:limit], len(issues) > limit || total > limit, nil
+		}
 		startAt += len(issuesChunk)
 	}
-	return issues, nil
+	// Issues updated during the pagination move between the pages, so they may be skipped or fetched twice
+	if total >= 0 && total != len(issues) {
+		fmt.Printf("Warning: Jira reported %d issues for %q on %s, but %d were paginated\n", total, jql, cfg.jiraURL, len(issues))
+		jiraExporterPaginationMismatch.WithLabelValues(instanceLabelValues(cfg)...).Inc()
+	}
+	return issues, false, nil
 }
 
-func fetchStartingFrom(cfg config, startAt int) ([]JiraIssue, error) {
-	fmt.Printf("Fetching Jira data starting from %d\n", startAt)
+// fetchStartingFrom fetches the page of the JQL query at startAt. It returns the total number of the query issues
+// reported by Jira, or -1 if the response has no total. The page number only samples the logs.
+func fetchStartingFrom(ctx context.Context, cfg config, jql string, startAt int, page int) ([]JiraIssue, int, error) {
+	logPage(cfg, page, "Fetching Jira data starting from %d\n", startAt)
 	// Adjust the API URL based on your Jira setup
-	jql := fmt.Sprintf("updated >= %s AND project in (%s)", getPeriod(cfg.analyzePeriod), cfg.projects)
-	apiURL := fmt.Sprintf("%s/rest/api/3/search?expand=changelog&fields=created,status,assignee,project,issuetype&startAt=%d&jql=%s", cfg.jiraURL, startAt, url.QueryEscape(jql))
-	fmt.Printf("Fetching %s\n", apiURL)
+	apiURL := fmt.Sprintf("%s/rest/api/3/search?%sfields=%s&startAt=%d&jql=%s", cfg.jiraURL, searchExpand(cfg), searchFields(cfg), startAt, url.QueryEscape(jql))
+	logPage(cfg, page, "Fetching %s\n", apiURL)
+
+	var result struct {
+		Issues []JiraIssue `json:"issues"`
+		Total  *int        `json:"total"`
+	}
+	if err := getJSON(ctx, cfg, apiURL, &result); err != nil {
+		return nil, 0, err
+	}
+	if result.Total == nil {
+		return result.Issues, -1, nil
+	}
+	return result.Issues, *result.Total, nil
+}
+
+// logPage prints the line of the fetched page, sampling every LOG_SAMPLE_EVERY_N_PAGES page starting from the first one
+func logPage(cfg config, page int, format string, a ...any) {
+	if cfg.logSampleEveryNPages > 1 && page%cfg.logSampleEveryNPages != 0 {
+		return
+	}
+	fmt.Printf(format, a...)
+}
+
+// fetchByJQLWithTokens fetches all pages of the JQL query with the enhanced search API, which paginates
+// with nextPageToken instead of startAt. It also returns whether issues over the limit were cut off.
+func fetchByJQLWithTokens(ctx context.Context, cfg config, jql string, limit int) ([]JiraIssue, bool, error) {
+	issues := make([]JiraIssue, 0)
+	nextPageToken := ""
+	for page := 0; ; page++ {
+		issuesChunk, token, err := fetchWithToken(ctx, cfg, jql, nextPageToken, page)
+		if err != nil {
+			return nil, false, err
+		}
+		issues = append(issues, issuesChunk...)
+		if limit > 0 && len(issues) >= limit {
+			return issues[:limit], len(issues) > limit || token != "", nil
+		}
+		if token == "" {
+			break
+		}
+		nextPageToken = token
+	}
+	return issues, false, nil
+}
+
+// fetchWithToken fetches the page of the JQL query by the page token, empty for the first page.
+// It returns the token of the next page, empty for the last page. The page number only samples the logs.
+func fetchWithToken(ctx context.Context, cfg config, jql string, pageToken string, page int) ([]JiraIssue, string, error) {
+	apiURL := fmt.Sprintf("%s/rest/api/3/search/jql?%sfields=%s&jql=%s", cfg.jiraURL, searchExpand(cfg), searchFields(cfg), url.QueryEscape(jql))
+	if pageToken != "" {
+		apiURL += "&nextPageToken=" + url.QueryEscape(pageToken)
+	}
+	logPage(cfg, page, "Fetching %s\n", apiURL)
+
+	var result struct {
+		Issues        []JiraIssue `json:"issues"`
+		NextPageToken string      `json:"nextPageToken"`
+	}
+	if err := getJSON(ctx, cfg, apiURL, &result); err != nil {
+		return nil, "", err
+	}
+	return result.Issues, result.NextPageToken, nil
+}
+
+// fetchFirstPage fetches the first page of the JQL query with the configured pagination
+func fetchFirstPage(ctx context.Context, cfg config, jql string) ([]JiraIssue, error) {
+	if cfg.tokenPagination {
+		issues, _, err := fetchWithToken(ctx, cfg, jql, "", 0)
+		return issues, err
+	}
+	issues, _, err := fetchStartingFrom(ctx, cfg, jql, 0, 0)
+	return issues, err
+}
+
+// searchExpand returns the expand parameter of the search requests. With CHANGELOG_EXPAND_WINDOW, the changelogs
+// are fetched separately by expandChangelogs.
+func searchExpand(cfg config) string {
+	if cfg.changelogExpandWindow > 0 {
+		return ""
+	}
+	return "expand=changelog&"
+}
+
+// expandChangelogs fetches the changelogs of the issues updated within CHANGELOG_EXPAND_WINDOW, which were searched
+// without them. The other issues keep empty changelogs, so the changelog metrics cover only the expanded issues.
+func expandChangelogs(ctx context.Context, cfg config, issues []JiraIssue) error {
+	if cfg.changelogExpandWindow == 0 {
+		return nil
+	}
+	since := cfg.now().Add(-cfg.changelogExpandWindow)
+	expanded := 0
+	for i := range issues {
+		// An issue without a valid update time isn't expanded
+		if updated, err := parseJiraTime(issues[i].Fields.Updated); err != nil || updated.Before(since) {
+			continue
+		}
+		histories, err := fetchChangelog(ctx, cfg, issues[i].Key)
+		if err != nil {
+			return err
+		}
+		issues[i].Changelog.Histories = histories
+		issues[i].Changelog.Total = len(histories)
+		expanded++
+	}
+	fmt.Printf("Fetched the changelogs of %d of %d issues from %s\n", expanded, len(issues), cfg.jiraURL)
+	return nil
+}
+
+// completeChangelogs fetches the rest of the changelogs that Jira truncated in the search response
+func completeChangelogs(ctx context.Context, cfg config, issues []JiraIssue) error {
+	for i := range issues {
+		changelog := &issues[i].Changelog
+		if changelog.Total <= len(changelog.Histories) {
+			continue
+		}
+		jiraIssueChangelogTruncated.WithLabelValues(instanceLabelValues(cfg)...).Inc()
+		histories, err := fetchChangelog(ctx, cfg, issues[i].Key)
+		if err != nil {
+			return err
+		}
+		changelog.Histories = histories
+	}
+	return nil
+}
+
+// fetchChangelog fetches all pages of the issue changelog. The histories are returned newest first,
+// in the same order as in the search response.
+func fetchChangelog(ctx context.Context, cfg config, key string) ([]ChangelogHistory, error) {
+	histories := make([]ChangelogHistory, 0)
+	for {
+		apiURL := fmt.Sprintf("%s/rest/api/3/issue/%s/changelog?startAt=%d", cfg.jiraURL, url.PathEscape(key), len(histories))
+		var page struct {
+			IsLast bool               `json:"isLast"`
+			Values []ChangelogHistory `json:"values"`
+		}
+		if err := getJSON(ctx, cfg, apiURL, &page); err != nil {
+			return nil, err
+		}
+		histories = append(histories, page.Values...)
+		if page.IsLast || len(page.Values) == 0 {
+			break
+		}
+	}
+	slices.Reverse(histories)
+	return histories, nil
+}
+
+// getJSON makes an authenticated GET request to the Jira API and decodes the JSON response into result
+func getJSON(ctx context.Context, cfg config, apiURL string, result any) (err error) {
+	defer func() {
+		if err != nil {
+			jiraFetchErrors.WithLabelValues(instanceLabelValues(cfg, classifyFetchError(err))...).Inc()
+		}
+	}()
+
+	// Wait for the rate limit
+	if cfg.limiter != nil {
+		if err := cfg.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Wait for a free slot of the global concurrency limit, held until the response is read
+	if cfg.fetchSlots != nil {
+		select {
+		case cfg.fetchSlots <- struct{}{}:
+			defer func() { <-cfg.fetchSlots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Set authentication headers
-	req.SetBasicAuth(cfg.jiraUser, cfg.jiraAPIToken)
+	req.Header.Set("User-Agent", cfg.userAgent)
+	// The transport decompresses the responses itself only if the header isn't set explicitly
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Set authentication headers. With OAuth, the client injects the bearer token itself
+	if cfg.oauth == nil {
+		req.SetBasicAuth(cfg.jiraUser, cfg.jiraAPIToken)
+	}
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	started := time.Now()
+	resp, err := cfg.client.Do(req)
+	statusClass := "error"
+	if err == nil {
+		statusClass = fmt.Sprintf("%dxx", resp.StatusCode/100)
+	}
+	jiraAPIRequestDuration.WithLabelValues(instanceLabelValues(cfg, apiEndpoint(req.URL.Path), statusClass)...).Observe(time.Since(started).Seconds())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// The bytes are counted as received, before the decompression
+	counted := &countingReader{reader: resp.Body}
+	// The connection is reused only if the body is read to the end
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(counted, maxErrorBodySize))
+		resp.Body.Close()
+		jiraExporterFetchBytes.WithLabelValues(instanceLabelValues(cfg)...).Add(float64(counted.n))
+	}()
+	body := io.Reader(counted)
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(counted)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		body = gzipReader
 	}
-	defer resp.Body.Close()
 
-	// Check if the response is successful
+	// Check if the response is successful. The error messages of Jira are kept for the error classification
+	// and the logs, without the credentials in case Jira echoes them
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch data: %s", resp.Status)
+		errorBody, _ := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+		return &JiraAPIError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Endpoint:   apiEndpoint(req.URL.Path),
+			Body:       redactSecrets(cfg, string(errorBody)),
+		}
 	}
 
 	// Decode the JSON response
-	var result struct {
-		Issues []JiraIssue `json:"issues"`
+	return json.NewDecoder(body).Decode(result)
+}
+
+// countingReader counts the bytes read from the reader
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// newTransport returns the default transport with the idle connection limits
+func newTransport(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	return transport
+}
+
+// checkRedirect follows the redirects within the Jira host, e.g. to HTTPS or another path, re-applying the credentials.
+// A redirect to another host is refused, so the credentials aren't sent there and the non-canonical JIRA_URL is reported.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("refused redirect from %s to another host %s, set JIRA_URL to the canonical URL", via[0].URL.Host, req.URL.Host)
+	}
+	if auth := via[0].Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
 	}
+	return nil
+}
 
-	return result.Issues, nil
+// apiEndpoint returns the Jira API path of the request with the issue key replaced by {key},
+// e.g. /rest/api/3/issue/{key}/changelog. The context path of the Jira URL is dropped.
+func apiEndpoint(path string) string {
+	if i := strings.Index(path, "/rest/"); i >= 0 {
+		path = path[i:]
+	}
+	const issuePrefix = "/rest/api/3/issue/"
+	if !strings.HasPrefix(path, issuePrefix) {
+		return path
+	}
+	_, rest, _ := strings.Cut(strings.TrimPrefix(path, issuePrefix), "/")
+	if rest == "" {
+		return issuePrefix + "{key}"
+	}
+	return issuePrefix + "{key}/" + rest
 }
 
-// Define Prometheus metrics
-var (
-	jiraIssueCount = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "jira_issue_count",
-			Help: "Count of Jira issues by various labels.",
-		},
-		[]string{"project", "priority", "status", "statusCategory", "assignee", "issueType"},
-	)
-	jiraIssueTimeInStatus = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "jira_issue_time_in_status",
-			Help:    "Time spent by issues in each status.",
-			Buckets: prometheus.ExponentialBuckets(1, 10, 8),
-		},
-		[]string{"project", "priority", "assignee", "issueType"},
-	)
-)
+// searchFields returns the comma-separated list of issue fields requested from Jira
+func searchFields(cfg config) string {
+	fields := slices.Clone(cfg.fields)
+	if cfg.storyPointsField != "" {
+		fields = append(fields, cfg.storyPointsField)
+	}
+	if cfg.sprintField != "" {
+		fields = append(fields, cfg.sprintField)
+	}
+	if cfg.epicField != "" {
+		fields = append(fields, cfg.epicField)
+	}
+	if slices.Contains(cfg.countLabels, "parentKey") && !slices.Contains(fields, "parent") {
+		fields = append(fields, "parent")
+	}
+	return strings.Join(fields, ",")
+}
+
+// parseFields parses the comma-separated list of requested issue fields, checking that it includes the fields
+// the exporter can't work without. An empty spec selects the default list.
+func parseFields(spec string, cfg config) ([]string, error) {
+	if spec == "" {
+		return []string{"created", "updated", "resolutiondate", "status", "assignee", "reporter", "priority", "project", "issuetype", "labels", "issuelinks"}, nil
+	}
+	fields := parseList(spec)
+	required := []string{"created", "status", "project", "issuetype"}
+	if cfg.fullRefreshInterval > 0 {
+		// The incremental fetching tracks the update times and evicts the issues by the window field
+		required = append(required, "updated", windowFields[cfg.windowField])
+	}
+	if cfg.changelogExpandWindow > 0 {
+		// The changelogs are expanded by the update times, without them none would be
+		required = append(required, "updated")
+	}
+	for _, field := range required {
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("required field %q is missing in %q", field, spec)
+		}
+	}
+	return fields, nil
+}
+
+// maxErrorBodySize limits the response body read on a non-200 status
+const maxErrorBodySize = 64 << 10
+
+// maxErrorSnippetSize limits the response body included in the error message
+const maxErrorSnippetSize = 2 << 10
+
+// JiraAPIError is returned when Jira responds with a non-200 status
+type JiraAPIError struct {
+	StatusCode int
+	Status     string
+	// Endpoint is the API path with the issue key replaced by {key}, as in jira_api_request_duration_seconds
+	Endpoint string
+	// Body is the beginning of the response body, usually the JSON with the Jira error messages
+	Body string
+}
+
+func (e *JiraAPIError) Error() string {
+	snippet := strings.Join(strings.Fields(e.Body), " ")
+	if snippet == "" {
+		return fmt.Sprintf("failed to fetch data from %s: %s", e.Endpoint, e.Status)
+	}
+	if len(snippet) > maxErrorSnippetSize {
+		snippet = strings.ToValidUTF8(snippet[:maxErrorSnippetSize], "") + "..."
+	}
+	return fmt.Sprintf("failed to fetch data from %s: %s: %s", e.Endpoint, e.Status, snippet)
+}
 
-func init() {
-	// Register metrics with Prometheus
-	prometheus.MustRegister(jiraIssueCount)
-	prometheus.MustRegister(jiraIssueTimeInStatus)
+// redactSecrets replaces the credentials of the config in s
+func redactSecrets(cfg config, s string) string {
+	secrets := []string{cfg.jiraAPIToken}
+	if cfg.oauth != nil {
+		secrets = append(secrets, cfg.oauth.ClientSecret)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "REDACTED")
+		}
+	}
+	return s
+}
+
+// classifyFetchError maps an error returned by getJSON to the cause label of jira_fetch_errors_total:
+// auth, timeout, ratelimit, server, decode, network or other
+func classifyFetchError(err error) string {
+	var apiErr *JiraAPIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return "auth"
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return "ratelimit"
+		case apiErr.StatusCode >= 500:
+			return "server"
+		default:
+			return "other"
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "decode"
+	}
+	return "other"
 }
 
 // JiraIssue represents the structure of an issue from Jira
 type JiraIssue struct {
 	Key       string `json:"key"`
 	Changelog struct {
-		Histories []struct {
-			Created string `json:"created"`
-			Items   []struct {
-				Field      string      `json:"field"`
-				FromString interface{} `json:"fromString"`
-			} `json:"items"`
-		} `json:"histories"`
+		MaxResults int                `json:"maxResults"`
+		Total      int                `json:"total"`
+		Histories  []ChangelogHistory `json:"histories"`
 	} `json:"changelog"`
 	Fields struct {
-		Created  string `json:"created"`
-		Priority struct {
+		Created        string   `json:"created"`
+		Updated        string   `json:"updated"`
+		ResolutionDate string   `json:"resolutiondate"`
+		Labels         []string `json:"labels"`
+		IssueLinks     []struct {
+			ID string `json:"id"`
+		} `json:"issuelinks"`
+		// Comment is requested only if listed in JIRA_FIELDS, since it carries the comment bodies
+		Comment struct {
+			Total int `json:"total"`
+		} `json:"comment"`
+		// Parent is the parent issue of a subtask, or of an issue in an epic on Jira Cloud
+		Parent *struct {
+			Key string `json:"key"`
+		} `json:"parent"`
+		Priority *struct {
 			Name string `json:"name"`
 		} `json:"priority"`
-		Assignee struct {
-			EmailAddress string `json:"emailAddress"`
-		} `json:"assignee"`
-		Status struct {
-			Name           string `json:"name"`
-			StatusCategory struct {
-				Name string `json:"name"`
-			} `json:"statusCategory"`
+		Assignee JiraUser `json:"assignee"`
+		Reporter JiraUser `json:"reporter"`
+		Status   struct {
+			Name           string         `json:"name"`
+			StatusCategory StatusCategory `json:"statusCategory"`
 		} `json:"status"`
 		IssueType struct {
 			Name string `json:"name"`
@@ -144,56 +864,396 @@ type JiraIssue struct {
 			Key string `json:"key"`
 		} `json:"project"`
 	} `json:"fields"`
+	// CustomFields holds the raw values of the issue's customfield_* fields
+	CustomFields map[string]json.RawMessage `json:"customFields,omitempty"`
+}
+
+// JiraUser is the user of an issue field, e.g. the assignee. The email may be hidden by the privacy settings.
+type JiraUser struct {
+	AccountID    string `json:"accountId"`
+	EmailAddress string `json:"emailAddress"`
+	DisplayName  string `json:"displayName"`
+}
+
+// StatusCategory is the category of a status. The name is localized to the language of the Jira instance,
+// so the categorization uses the language-independent key: new, indeterminate or done.
+type StatusCategory struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// ChangelogHistory is a group of field changes made at once
+type ChangelogHistory struct {
+	Created string          `json:"created"`
+	Items   []ChangelogItem `json:"items"`
+}
+
+// ChangelogItem is a change of a single field
+type ChangelogItem struct {
+	Field      string      `json:"field"`
+	From       string      `json:"from"`
+	FromString interface{} `json:"fromString"`
+	To         string      `json:"to"`
+	ToString   interface{} `json:"toString"`
+}
+
+// UnmarshalJSON decodes the issue and collects its custom fields into CustomFields
+func (i *JiraIssue) UnmarshalJSON(data []byte) error {
+	type plainIssue JiraIssue
+	if err := json.Unmarshal(data, (*plainIssue)(i)); err != nil {
+		return err
+	}
+	var raw struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for name, value := range raw.Fields {
+		if !strings.HasPrefix(name, "customfield_") {
+			continue
+		}
+		if i.CustomFields == nil {
+			i.CustomFields = make(map[string]json.RawMessage)
+		}
+		i.CustomFields[name] = value
+	}
+	return nil
+}
+
+// numericCustomField returns the numeric value of the custom field. The second result is false
+// if the field is absent, null or non-numeric.
+func numericCustomField(issue JiraIssue, name string) (float64, bool) {
+	value, ok := issue.CustomFields[name]
+	if !ok {
+		return 0, false
+	}
+	var number *float64
+	if err := json.Unmarshal(value, &number); err == nil {
+		if number == nil {
+			return 0, false
+		}
+		return *number, true
+	}
+	var str string
+	if err := json.Unmarshal(value, &str); err != nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parentKey returns the key of the issue's parent, or "none"
+func parentKey(issue JiraIssue) string {
+	if issue.Fields.Parent == nil || issue.Fields.Parent.Key == "" {
+		return "none"
+	}
+	return issue.Fields.Parent.Key
+}
+
+// dataQualityIssues returns the kinds of missing or invalid data of the issue:
+// no_assignee, no_priority, bad_timestamp and no_status. The optional fields are checked only if requested.
+func dataQualityIssues(cfg config, issue JiraIssue) []string {
+	kinds := make([]string, 0)
+	if slices.Contains(cfg.fields, "assignee") && !hasAssignee(issue) {
+		kinds = append(kinds, "no_assignee")
+	}
+	if slices.Contains(cfg.fields, "priority") && priorityName(issue) == "none" {
+		kinds = append(kinds, "no_priority")
+	}
+	if !hasValidTimestamps(issue) {
+		kinds = append(kinds, "bad_timestamp")
+	}
+	if issue.Fields.Status.Name == "" {
+		kinds = append(kinds, "no_status")
+	}
+	return kinds
+}
+
+// fieldChanges counts the changes of the fields in the issue changelog. The fields are matched case-insensitively
+// and keyed as given, fields without changes are omitted.
+func fieldChanges(issue JiraIssue, fields []string) map[string]int {
+	changes := make(map[string]int)
+	for _, history := range issue.Changelog.Histories {
+		for _, item := range history.Items {
+			for _, field := range fields {
+				if strings.EqualFold(item.Field, field) {
+					changes[field]++
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// hasValidTimestamps checks that the creation time and the changelog times of the issue can be parsed
+func hasValidTimestamps(issue JiraIssue) bool {
+	if _, err := parseJiraTime(issue.Fields.Created); err != nil {
+		return false
+	}
+	for _, history := range issue.Changelog.Histories {
+		if _, err := parseJiraTime(history.Created); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// firstStatusTransition returns the time of the earliest status change. The second result is false
+// if the status has never changed.
+func firstStatusTransition(issue JiraIssue) (time.Time, bool) {
+	var first time.Time
+	for _, history := range issue.Changelog.Histories {
+		if !slices.ContainsFunc(history.Items, func(item ChangelogItem) bool { return item.Field == "status" }) {
+			continue
+		}
+		changeTime := mustTimeParse(history.Created)
+		if first.IsZero() || changeTime.Before(first) {
+			first = changeTime
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// cycleTimeStart returns the time of the first transition into any of the start statuses. The second result
+// is false if the issue has never entered them.
+func cycleTimeStart(issue JiraIssue, startStatuses []string) (time.Time, bool) {
+	var start time.Time
+	for _, history := range issue.Changelog.Histories {
+		if !slices.ContainsFunc(history.Items, func(item ChangelogItem) bool {
+			to, ok := item.ToString.(string)
+			return item.Field == "status" && ok && slices.ContainsFunc(startStatuses, func(s string) bool { return strings.EqualFold(s, to) })
+		}) {
+			continue
+		}
+		changeTime := mustTimeParse(history.Created)
+		if start.IsZero() || changeTime.Before(start) {
+			start = changeTime
+		}
+	}
+	return start, !start.IsZero()
+}
+
+// isStatusIn checks that the status is one of the statuses, ignoring the case
+func isStatusIn(status string, statuses []string) bool {
+	return slices.ContainsFunc(statuses, func(s string) bool { return strings.EqualFold(s, status) })
+}
+
+// wasBlocked checks that the issue is in one of the blocked statuses or has ever entered one of them
+func wasBlocked(issue JiraIssue, blockedStatuses []string) bool {
+	if isStatusIn(issue.Fields.Status.Name, blockedStatuses) {
+		return true
+	}
+	for _, history := range issue.Changelog.Histories {
+		for _, item := range history.Items {
+			if to, ok := item.ToString.(string); ok && item.Field == "status" && isStatusIn(to, blockedStatuses) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastChange returns the time of the latest changelog entry. The second result is false if the issue has never changed.
+func lastChange(issue JiraIssue) (time.Time, bool) {
+	var last time.Time
+	for _, history := range issue.Changelog.Histories {
+		if changeTime := mustTimeParse(history.Created); changeTime.After(last) {
+			last = changeTime
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// isIssueEmitted checks that the issue is included in the metrics by the issue type and by EXCLUDE_RESOLVED_BEFORE
+func isIssueEmitted(cfg config, issue JiraIssue, now time.Time) bool {
+	if !isIssueTypeIncluded(cfg, issue.Fields.IssueType.Name) {
+		return false
+	}
+	if cfg.excludeResolvedBefore == 0 {
+		return true
+	}
+	// Unresolved issues and invalid resolution dates are kept
+	resolved, err := parseJiraTime(issue.Fields.ResolutionDate)
+	return err != nil || !resolved.Before(now.Add(-cfg.excludeResolvedBefore))
+}
+
+// isIssueTypeIncluded checks the issue type against the include and exclude lists. Exclusion takes precedence,
+// and an empty include list includes all types.
+func isIssueTypeIncluded(cfg config, issueType string) bool {
+	matches := func(t string) bool { return strings.EqualFold(t, issueType) }
+	if slices.ContainsFunc(cfg.excludeIssueTypes, matches) {
+		return false
+	}
+	return len(cfg.includeIssueTypes) == 0 || slices.ContainsFunc(cfg.includeIssueTypes, matches)
+}
+
+// isDone checks that the issue is in the done status category
+func isDone(issue JiraIssue) bool {
+	return issue.Fields.Status.StatusCategory.Key == "done"
+}
+
+// assigneeLabel returns the assignee label value from the configured source: the email, the account ID
+// or the display name. Unassigned issues and assignees hiding the source field, e.g. the email, get "unassigned".
+func assigneeLabel(cfg config, issue JiraIssue) string {
+	return userLabel(issue.Fields.Assignee, cfg.assigneeLabelSource, "unassigned")
+}
+
+// reporterLabel returns the reporter label value from the configured source, as assigneeLabel does.
+// Reporters hiding the source field get "unknown".
+func reporterLabel(cfg config, issue JiraIssue) string {
+	return userLabel(issue.Fields.Reporter, cfg.reporterLabelSource, "unknown")
+}
+
+// userLabel returns the user field of the source: email, accountId or displayName. Jira Cloud often hides the email,
+// so an empty field falls back to the account ID, then to the display name, but never to the email. A user without
+// any of them gets the fallback.
+func userLabel(user JiraUser, source string, fallback string) string {
+	values := map[string]string{
+		"email":       user.EmailAddress,
+		"accountId":   user.AccountID,
+		"displayName": user.DisplayName,
+	}
+	for _, s := range []string{source, "accountId", "displayName"} {
+		if values[s] != "" {
+			return values[s]
+		}
+	}
+	return fallback
+}
+
+// hasAssignee checks that the issue is assigned. The assignee may hide the email, but always has an account ID.
+func hasAssignee(issue JiraIssue) bool {
+	assignee := issue.Fields.Assignee
+	return assignee.AccountID != "" || assignee.EmailAddress != "" || assignee.DisplayName != ""
 }
 
-// transformDataForPrometheus updates Prometheus metrics instead of returning a string
-func transformDataForPrometheus(issue JiraIssue) {
-	//fmt.Printf("Processing issue %s\n", issue.Key)
-	jiraIssueCount.With(prometheus.Labels{
-		"project":        issue.Fields.Project.Key,
-		"priority":       issue.Fields.Priority.Name,
-		"status":         issue.Fields.Status.Name,
-		"statusCategory": issue.Fields.Status.StatusCategory.Name,
-		"assignee":       issue.Fields.Assignee.EmailAddress,
-		"issueType":      issue.Fields.IssueType.Name,
-	}).Inc()
-	calculateStatusDurations(issue)
+// priorityName returns the issue priority, or "none" for issue types without one (e.g. Epics)
+func priorityName(issue JiraIssue) string {
+	if issue.Fields.Priority == nil || issue.Fields.Priority.Name == "" {
+		return "none"
+	}
+	return issue.Fields.Priority.Name
+}
+
+// sortedStatuses returns the statuses of the durations in the sorted order, as the map order is random
+func sortedStatuses(durations map[string]time.Duration) []string {
+	statuses := make([]string, 0, len(durations))
+	for status := range durations {
+		statuses = append(statuses, status)
+	}
+	slices.Sort(statuses)
+	return statuses
 }
 
-func calculateStatusDurations(issue JiraIssue) {
-	statusDurations := make(map[string]time.Duration)
+// statusDurations returns the total time the issue spent in each of its previous statuses, and the number of
+// negative durations clamped to zero, e.g. of status changes dated before the issue creation
+func statusDurations(cfg config, issue JiraIssue) (map[string]time.Duration, int) {
+	durations := make(map[string]time.Duration)
+	negative := 0
 
-	slices.Reverse(issue.Changelog.Histories)
+	// Histories go newest first, but Jira occasionally returns them slightly out of order. Sort a copy,
+	// the issue may be retained between refreshes
+	histories := slices.Clone(issue.Changelog.Histories)
+	slices.Reverse(histories)
+	slices.SortStableFunc(histories, func(a, b ChangelogHistory) int {
+		return mustTimeParse(a.Created).Compare(mustTimeParse(b.Created))
+	})
 	statusChangeTime := mustTimeParse(issue.Fields.Created)
-	for _, history := range issue.Changelog.Histories {
+	for _, history := range histories {
 		changeTime := mustTimeParse(history.Created)
 		for _, item := range history.Items {
-			if item.Field == "status" {
-				duration := changeTime.Sub(statusChangeTime)
-				statusDurations[item.FromString.(string)] += duration
+			if item.Field != "status" {
+				continue
+			}
+			duration := time.Duration(0)
+			if changeTime.Before(statusChangeTime) {
+				negative++
+			} else {
+				duration = statusDuration(cfg, statusChangeTime, changeTime)
+			}
+			// A change without the previous status name can't be attributed, but still ends the status
+			if from, ok := item.FromString.(string); ok {
+				durations[from] += duration
+			}
+			if changeTime.After(statusChangeTime) {
 				statusChangeTime = changeTime
 			}
 		}
 	}
-	for _, duration := range statusDurations {
-		//fmt.Printf("Issue %s spent %s in status %s\n", issue.Key, duration, status)
-		jiraIssueTimeInStatus.With(prometheus.Labels{
-			"project":   issue.Fields.Project.Key,
-			"priority":  issue.Fields.Priority.Name,
-			"assignee":  issue.Fields.Assignee.EmailAddress,
-			"issueType": issue.Fields.IssueType.Name,
-		}).Observe(duration.Seconds())
+	return durations, negative
+}
+
+// statusDuration returns the time between from and to, counting only the working hours in the business calendar mode
+func statusDuration(cfg config, from, to time.Time) time.Duration {
+	if cfg.businessCalendar == nil {
+		return to.Sub(from)
 	}
+	return cfg.businessCalendar.workingDuration(from, to)
 }
 
 // exposeMetrics serves the Prometheus metrics using promhttp
-func exposeMetrics(cfg config) {
-	http.Handle("/liveness", livenessHandler())
-	http.Handle("/readiness", readinessHandler(cfg))
-	http.Handle("/metrics", promhttp.Handler())
+func exposeMetrics(ctx context.Context, cfg config) {
 	fmt.Printf("Serving metrics on %s\n", cfg.listen)
-	err := http.ListenAndServe(cfg.listen, nil)
-	if err != nil {
+	serve(ctx, cfg.listen, metricsHandler(cfg))
+}
+
+// metricsHandler routes the metrics, the probes and the enabled debug endpoints
+func metricsHandler(cfg config) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/liveness", livenessHandler())
+	mux.Handle("/readiness", readinessHandler(cfg))
+	mux.Handle("/startup", startupHandler(cfg))
+	mux.Handle("/config", configHandler(cfg))
+	if cfg.debugEndpointsEnabled {
+		mux.Handle("/debug/issue", debugIssueHandler(cfg))
+	}
+	if cfg.refreshEndpointEnabled {
+		mux.Handle("/refresh", refreshHandler(cfg))
+	}
+	// Exemplars are exposed only in the OpenMetrics format
+	metrics := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: cfg.enableExemplars}),
+	)
+	if cfg.scrapeMode == "pull" {
+		metrics = pullHandler(cfg, metrics)
+	}
+	mux.Handle("/metrics", metrics)
+	if cfg.httpPathPrefix == "" {
+		return mux
+	}
+	prefixed := http.NewServeMux()
+	prefixed.Handle(cfg.httpPathPrefix+"/", http.StripPrefix(cfg.httpPathPrefix, mux))
+	return prefixed
+}
+
+// parsePathPrefix normalizes the path prefix of the endpoints to a leading slash without a trailing one
+func parsePathPrefix(prefix string) (string, error) {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("HTTP_PATH_PREFIX must start with /, got %q", prefix)
+	}
+	return prefix, nil
+}
+
+// serve serves the handler on the address until the context is done
+func serve(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Println("Error starting HTTP server:", err)
 	}
 }
@@ -204,79 +1264,736 @@ func livenessHandler() http.Handler {
 	})
 }
 
+// readinessHandler reports ready while the last successful refresh is fresher than cfg.readinessMaxAge and
+// fewer than cfg.readinessFailureThreshold refreshes failed since. It never calls Jira, so frequent probes
+// don't add upstream load.
 func readinessHandler(cfg config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := fetchStartingFrom(cfg, 0)
-		if err != nil {
-			fmt.Printf("Error fetching Jira data: %s\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if !isFresh(lastSuccessfulRefresh.Load(), cfg.readinessMaxAge, time.Now()) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// A single transient failure keeps the exporter ready
+		if cfg.readinessFailureThreshold > 0 && consecutiveRefreshFailures.Load() >= int64(cfg.readinessFailureThreshold) {
+			w.WriteHeader(http.StatusServiceUnavailable)
 			return
-		} else {
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// isFresh checks that lastSuccess (UnixNano, 0 if never) is not older than maxAge at now
+func isFresh(lastSuccess int64, maxAge time.Duration, now time.Time) bool {
+	if lastSuccess == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, lastSuccess)) <= maxAge
+}
+
+// startupHandler checks the connectivity to Jira with a live request. Once it succeeds,
+// the result is remembered and Jira is not called again.
+func startupHandler(cfg config) http.Handler {
+	var started atomic.Bool
+	check := &cachedCheck{
+		ttl:     cfg.readinessCacheTTL,
+		timeout: 10 * time.Second,
+		check: func(ctx context.Context) error {
+			for _, instance := range cfg.instances {
+				if err := checkConnectivity(ctx, instance); err != nil {
+					return fmt.Errorf("%s: %w", instance.jiraURL, err)
+				}
+			}
+			return nil
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if started.Load() {
 			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := check.result(r.Context()); err != nil {
+			fmt.Printf("Error fetching Jira data: %s\n", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		started.Store(true)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// refreshHandler refreshes the data out of schedule on POST and responds with the number of fetched issues.
+// The scheduled refreshes keep their timer.
+func refreshHandler(cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fetched, err := refresh(r.Context(), cfg)
+		if err != nil {
+			fmt.Println("Error fetching Jira data:", err)
+			jiraExporterScrapeErrors.Inc()
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Fetched int `json:"fetched"`
+		}{fetched})
+	})
+}
+
+// pullHandler refreshes the data before serving the scrape, at most once per cfg.dataRefreshPeriod.
+// A failed refresh is also not retried until then, and the scrape gets the metrics of the previous one.
+func pullHandler(cfg config, next http.Handler) http.Handler {
+	var mu sync.Mutex
+	var refreshedAt time.Time
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if refreshedAt.IsZero() || time.Since(refreshedAt) >= cfg.dataRefreshPeriod {
+			refreshedAt = time.Now()
+			if _, err := refresh(r.Context(), cfg); err != nil {
+				fmt.Println("Error fetching Jira data:", err)
+				jiraExporterScrapeErrors.Inc()
+			}
 		}
+		mu.Unlock()
+		next.ServeHTTP(w, r)
 	})
 }
 
+// checkConnectivity makes the cheapest authenticated request to Jira, fetching the current user
+func checkConnectivity(ctx context.Context, cfg config) error {
+	var user struct{}
+	return getJSON(ctx, cfg, cfg.jiraURL+"/rest/api/3/myself", &user)
+}
+
+// cachedCheck caches the result of check for ttl, so bursts of probes collapse into a single upstream call
+type cachedCheck struct {
+	ttl time.Duration
+	// timeout limits the check, which runs detached from the cancellation of the probe that triggered it
+	timeout time.Duration
+	check   func(ctx context.Context) error
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func (c *cachedCheck) result(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.ttl {
+		return c.err
+	}
+	// The result is shared by the probes, so it must not depend on whether the first caller gave up
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	c.err = c.check(checkCtx)
+	c.checkedAt = time.Now()
+	return c.err
+}
+
 func main() {
 	var err error
+	// Cancel the running fetches and stop the server on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fileSettings, err = loadConfigFile(os.Getenv("CONFIG_FILE"))
+	failOnError(err)
 	cfg := config{
-		listen:        getEnvOrDie("LISTEN"),
 		analyzePeriod: getEnvOrDefault("ANALYZE_PERIOD", "90"),
-		jiraURL:       getEnvOrDie("JIRA_URL"),
-		jiraUser:      getEnvOrDie("JIRA_USER"),
-		jiraAPIToken:  getEnvOrDie("JIRA_API_TOKEN"),
-		projects:      getEnvOrDie("JIRA_PROJECTS"),
 	}
+	// A hung request would block the refresh forever
+	cfg.requestTimeout, err = time.ParseDuration(getEnvOrDefault("JIRA_REQUEST_TIMEOUT", "30s"))
+	failOnError(err)
+	// The connections are kept alive between the pages and the refreshes, by default Go keeps only 2 idle per host
+	maxIdleConns, err := strconv.Atoi(getEnvOrDefault("HTTP_MAX_IDLE_CONNS", "100"))
+	failOnError(err)
+	maxIdleConnsPerHost, err := strconv.Atoi(getEnvOrDefault("HTTP_MAX_IDLE_CONNS_PER_HOST", "10"))
+	failOnError(err)
+	idleConnTimeout, err := time.ParseDuration(getEnvOrDefault("HTTP_IDLE_CONN_TIMEOUT", "90s"))
+	failOnError(err)
+	cfg.transport = newTransport(maxIdleConns, maxIdleConnsPerHost, idleConnTimeout)
+	cfg.jiraRPS, err = strconv.ParseFloat(getEnvOrDefault("JIRA_RPS", "0"), 64)
+	failOnError(err)
+	if cfg.jiraRPS < 0 {
+		failOnError(fmt.Errorf("JIRA_RPS must not be negative, got %v", cfg.jiraRPS))
+	}
+	globalFetchConcurrency, err := strconv.Atoi(getEnvOrDefault("GLOBAL_FETCH_CONCURRENCY", "0"))
+	failOnError(err)
+	if globalFetchConcurrency < 0 {
+		failOnError(fmt.Errorf("GLOBAL_FETCH_CONCURRENCY must not be negative, got %d", globalFetchConcurrency))
+	}
+	if globalFetchConcurrency > 0 {
+		cfg.fetchSlots = make(chan struct{}, globalFetchConcurrency)
+	}
+	cfg.maxIssues, err = strconv.Atoi(getEnvOrDefault("MAX_ISSUES", "0"))
+	failOnError(err)
+	if cfg.maxIssues < 0 {
+		failOnError(fmt.Errorf("MAX_ISSUES must not be negative, got %d", cfg.maxIssues))
+	}
+	cfg.userAgent = getEnvOrDefault("JIRA_USER_AGENT", "jira-issues-exporter/"+version)
+	cfg.dryRun, err = strconv.ParseBool(getEnvOrDefault("DRY_RUN", "false"))
+	failOnError(err)
+	if !cfg.dryRun {
+		cfg.listen = getEnvOrDie("LISTEN")
+	}
+	cfg.snapshotPath = getEnvOrDefault("SNAPSHOT_PATH", "")
+	cfg.metricNamespace = getEnvOrDefault("METRIC_NAMESPACE", "")
+	cfg.metricSubsystem = getEnvOrDefault("METRIC_SUBSYSTEM", "")
+	cfg.staticLabels, err = parseStaticLabels(getEnvOrDefault("STATIC_LABELS", ""))
+	failOnError(err)
+	switch pagination := getEnvOrDefault("JIRA_PAGINATION", "startAt"); pagination {
+	case "startAt":
+	case "token":
+		cfg.tokenPagination = true
+	default:
+		failOnError(fmt.Errorf("invalid JIRA_PAGINATION %q, must be startAt or token", pagination))
+	}
+	cfg.debugEndpointsEnabled, err = strconv.ParseBool(getEnvOrDefault("DEBUG_ENDPOINTS_ENABLED", "false"))
+	failOnError(err)
+	cfg.refreshEndpointEnabled, err = strconv.ParseBool(getEnvOrDefault("REFRESH_ENDPOINT_ENABLED", "false"))
+	failOnError(err)
+	cfg.httpPathPrefix, err = parsePathPrefix(getEnvOrDefault("HTTP_PATH_PREFIX", ""))
+	failOnError(err)
+	enablePprof, err := strconv.ParseBool(getEnvOrDefault("ENABLE_PPROF", "false"))
+	failOnError(err)
+	if enablePprof {
+		cfg.pprofListen = getEnvOrDefault("PPROF_LISTEN", "localhost:6060")
+	}
+	cfg.enableStatusSummary, err = strconv.ParseBool(getEnvOrDefault("ENABLE_STATUS_SUMMARY", "false"))
+	failOnError(err)
+	cfg.enableExemplars, err = strconv.ParseBool(getEnvOrDefault("ENABLE_EXEMPLARS", "false"))
+	failOnError(err)
+	cfg.storyPointsField = getEnvOrDefault("JIRA_STORY_POINTS_FIELD", "")
+	cfg.sprintField = getEnvOrDefault("JIRA_SPRINT_FIELD", "")
+	cfg.epicField = getEnvOrDefault("JIRA_EPIC_FIELD", "")
+	cfg.includeIssueTypes = parseList(getEnvOrDefault("INCLUDE_ISSUE_TYPES", ""))
+	cfg.excludeIssueTypes = parseList(getEnvOrDefault("EXCLUDE_ISSUE_TYPES", ""))
+	cfg.trackFields = parseList(getEnvOrDefault("CHANGELOG_TRACK_FIELDS", ""))
+	cfg.ignoreStatuses = parseList(getEnvOrDefault("IGNORE_STATUSES", ""))
+	cfg.enabledMetrics = parseList(getEnvOrDefault("ENABLED_METRICS", ""))
+	cfg.disabledMetrics = parseList(getEnvOrDefault("DISABLED_METRICS", ""))
+	cfg.slaThresholds, err = parseSLAThresholds(getEnvOrDefault("SLA_THRESHOLDS", ""))
+	failOnError(err)
+	cfg.issueTypeBuckets, err = parseIssueTypeBuckets(getEnvOrDefault("TIME_IN_STATUS_ISSUE_TYPE_BUCKETS", ""))
+	failOnError(err)
+	cfg.openAgeBuckets, err = parseOpenAgeBuckets(getEnvOrDefault("OPEN_AGE_BUCKETS", "1d,3d,7d"))
+	failOnError(err)
+	cfg.cycleTimeStartStatuses = parseList(getEnvOrDefault("CYCLE_TIME_START_STATUSES", "In Progress"))
+	cfg.blockedStatuses = parseList(getEnvOrDefault("BLOCKED_STATUSES", "Blocked"))
+	if excludeResolvedBefore := getEnvOrDefault("EXCLUDE_RESOLVED_BEFORE", ""); excludeResolvedBefore != "" {
+		cfg.excludeResolvedBefore, err = parseDurationWithDays(excludeResolvedBefore)
+		failOnError(err)
+		if cfg.excludeResolvedBefore <= 0 {
+			failOnError(fmt.Errorf("EXCLUDE_RESOLVED_BEFORE must be positive, got %s", excludeResolvedBefore))
+		}
+	}
+	if changelogExpandWindow := getEnvOrDefault("CHANGELOG_EXPAND_WINDOW", ""); changelogExpandWindow != "" {
+		cfg.changelogExpandWindow, err = parseDurationWithDays(changelogExpandWindow)
+		failOnError(err)
+		if cfg.changelogExpandWindow <= 0 {
+			failOnError(fmt.Errorf("CHANGELOG_EXPAND_WINDOW must be positive, got %s", changelogExpandWindow))
+		}
+	}
+	cfg.logSampleEveryNPages, err = strconv.Atoi(getEnvOrDefault("LOG_SAMPLE_EVERY_N_PAGES", "1"))
+	failOnError(err)
+	if cfg.logSampleEveryNPages < 1 {
+		failOnError(fmt.Errorf("LOG_SAMPLE_EVERY_N_PAGES must be positive, got %d", cfg.logSampleEveryNPages))
+	}
+	for _, layout := range strings.Split(getEnvOrDefault("JIRA_TIME_FORMATS", ""), ";") {
+		if layout = strings.TrimSpace(layout); layout != "" {
+			jiraTimeLayouts = append(jiraTimeLayouts, layout)
+		}
+	}
+	cfg.location, err = time.LoadLocation(getEnvOrDefault("TIMEZONE", "UTC"))
+	failOnError(err)
+	businessHoursEnabled, err := strconv.ParseBool(getEnvOrDefault("BUSINESS_HOURS_ENABLED", "false"))
+	failOnError(err)
+	if businessHoursEnabled {
+		cfg.businessCalendar, err = newBusinessCalendar(
+			getEnvOrDefault("BUSINESS_HOURS_START", "09:00"),
+			getEnvOrDefault("BUSINESS_HOURS_END", "18:00"),
+			getEnvOrDefault("BUSINESS_DAYS", "Mon,Tue,Wed,Thu,Fri"),
+			cfg.location,
+		)
+		failOnError(err)
+	}
+	cfg.assigneeLabelSource = getEnvOrDefault("ASSIGNEE_LABEL_SOURCE", "email")
+	if !slices.Contains([]string{"email", "accountId", "displayName"}, cfg.assigneeLabelSource) {
+		failOnError(fmt.Errorf("invalid ASSIGNEE_LABEL_SOURCE %q, must be email, accountId or displayName", cfg.assigneeLabelSource))
+	}
+	cfg.reporterLabelSource = getEnvOrDefault("REPORTER_LABEL_SOURCE", "email")
+	if !slices.Contains([]string{"email", "accountId", "displayName"}, cfg.reporterLabelSource) {
+		failOnError(fmt.Errorf("invalid REPORTER_LABEL_SOURCE %q, must be email, accountId or displayName", cfg.reporterLabelSource))
+	}
+	cfg.countLabels, err = parseCountLabels(getEnvOrDefault("COUNT_LABELS", ""), cfg)
+	failOnError(err)
 	cfg.dataRefreshPeriod, err = time.ParseDuration(getEnvOrDefault("DATA_REFRESH_PERIOD", "5m"))
 	failOnError(err)
+	switch cfg.scrapeMode = getEnvOrDefault("SCRAPE_MODE", "poll"); cfg.scrapeMode {
+	case "poll", "pull":
+	default:
+		failOnError(fmt.Errorf("invalid SCRAPE_MODE %q, must be poll or pull", cfg.scrapeMode))
+	}
+	cfg.refreshJitter, err = parseRefreshJitter(getEnvOrDefault("REFRESH_JITTER", "0"))
+	failOnError(err)
+	cfg.initialScrapeTimeout, err = time.ParseDuration(getEnvOrDefault("INITIAL_SCRAPE_TIMEOUT", "0"))
+	failOnError(err)
+	cfg.fullRefreshInterval, err = time.ParseDuration(getEnvOrDefault("FULL_REFRESH_INTERVAL", "0"))
+	failOnError(err)
+	cfg.windowField, err = parseWindowField(getEnvOrDefault("JIRA_WINDOW_FIELD", "updated"))
+	failOnError(err)
+	cfg.fields, err = parseFields(getEnvOrDefault("JIRA_FIELDS", ""), cfg)
+	failOnError(err)
+	cfg.readinessMaxAge, err = time.ParseDuration(getEnvOrDefault("READINESS_MAX_AGE", (3 * cfg.dataRefreshPeriod).String()))
+	failOnError(err)
+	cfg.readinessCacheTTL, err = time.ParseDuration(getEnvOrDefault("READINESS_CACHE_TTL", "15s"))
+	failOnError(err)
+	cfg.readinessFailureThreshold, err = strconv.Atoi(getEnvOrDefault("READINESS_FAILURE_THRESHOLD", "3"))
+	failOnError(err)
+	if cfg.readinessFailureThreshold < 1 {
+		failOnError(fmt.Errorf("READINESS_FAILURE_THRESHOLD must be positive, got %d", cfg.readinessFailureThreshold))
+	}
+	cfg.metricGroups, err = loadMetricGroups(cfg)
+	failOnError(err)
+	maxAnalyzePeriodDays, err := strconv.Atoi(getEnvOrDefault("MAX_ANALYZE_PERIOD_DAYS", "365"))
+	failOnError(err)
+	// The instances copy the config, so it must be complete at this point
+	cfg.instances, err = loadInstances(cfg, time.Duration(maxAnalyzePeriodDays)*day)
+	failOnError(err)
+	for _, instance := range cfg.instances {
+		cfg.windowClamped = cfg.windowClamped || instance.windowClamped
+	}
 
-	// Repeat every cfg.dataRefreshPeriod and fetch Jira data
-	go func() {
-		for {
-			jiraIssueCount.Reset()
-			jiraIssueTimeInStatus.Reset()
-			now := time.Now()
-			issues, err := fetchJiraData(cfg)
+	registerMetrics(cfg)
+	if cfg.dryRun {
+		failOnError(dryRun(ctx, cfg, os.Stdout))
+		return
+	}
+	if cfg.snapshotPath != "" {
+		if err := restoreSnapshot(cfg.snapshotPath, cfg); err != nil {
+			fmt.Printf("Error restoring the snapshot, waiting for the first refresh: %s\n", err)
+		}
+	}
+
+	// Repeat every cfg.dataRefreshPeriod and fetch Jira data, unless the scrapes trigger the refreshes
+	if cfg.scrapeMode == "poll" {
+		go superviseRefreshLoop(ctx, cfg, func(ctx context.Context) error {
+			_, err := refresh(ctx, cfg)
+			return err
+		})
+	}
+
+	if cfg.pprofListen != "" {
+		go servePprof(ctx, cfg.pprofListen)
+	}
+	exposeMetrics(ctx, cfg)
+}
+
+// refreshRestartBackoff is the delay before the first restart of a panicked refresh loop. It doubles with every
+// restart up to cfg.dataRefreshPeriod.
+var refreshRestartBackoff = time.Second
+
+// superviseRefreshLoop runs the refresh loop until the context is done, restarting it after a panic,
+// so a bug in a single refresh doesn't freeze the metrics until the pod is restarted
+func superviseRefreshLoop(ctx context.Context, cfg config, refresh func(ctx context.Context) error) {
+	backoff := refreshRestartBackoff
+	for refreshLoopPanicked(ctx, cfg, refresh) {
+		jiraExporterRefreshRestarts.Inc()
+		fmt.Printf("Restarting the refresh loop in %s\n", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, cfg.dataRefreshPeriod)
+	}
+}
+
+// refreshLoopPanicked runs the refresh loop and reports whether it panicked
+func refreshLoopPanicked(ctx context.Context, cfg config, refresh func(ctx context.Context) error) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Refresh loop panicked: %v\n%s", r, debug.Stack())
+			panicked = true
+		}
+	}()
+	refreshLoop(ctx, cfg, refresh)
+	return false
+}
+
+// refreshLoop calls refresh every cfg.dataRefreshPeriod until the context is done. A failed refresh is logged
+// and counted, and the next one runs as scheduled. The first refresh is bounded by cfg.initialScrapeTimeout,
+// and retried at once if it times out.
+func refreshLoop(ctx context.Context, cfg config, refresh func(ctx context.Context) error) {
+	// Spread the first refreshes of pods started together
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(rand.Float64() * cfg.refreshJitter * float64(cfg.dataRefreshPeriod))):
+	}
+	// lastStarted is the start of the previous scheduled iteration, zero if there is none
+	var lastStarted time.Time
+	for first := true; ctx.Err() == nil; first = false {
+		started := time.Now()
+		jiraExporterRefreshIterations.Inc()
+		if !lastStarted.IsZero() {
+			jiraExporterRefreshIntervalSkew.Set((started.Sub(lastStarted) - cfg.dataRefreshPeriod).Seconds())
+		}
+		lastStarted = started
+		refreshCtx, cancel := ctx, context.CancelFunc(func() {})
+		if first && cfg.initialScrapeTimeout > 0 {
+			refreshCtx, cancel = context.WithTimeout(ctx, cfg.initialScrapeTimeout)
+		}
+		err := refresh(refreshCtx)
+		timedOut := refreshCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if err != nil {
+			fmt.Println("Error fetching Jira data:", err)
+			jiraExporterScrapeErrors.Inc()
+		}
+		// The bounded first refresh is retried right away without the bound
+		if first && timedOut {
+			fmt.Println("The first refresh exceeded INITIAL_SCRAPE_TIMEOUT, retrying")
+			// The retry isn't scheduled, so it has no skew
+			lastStarted = time.Time{}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(jitteredDuration(cfg.dataRefreshPeriod, cfg.refreshJitter)):
+		}
+	}
+}
+
+// parseRefreshJitter parses the jitter fraction. With 1 or more, the refreshes could run back-to-back.
+func parseRefreshJitter(s string) (float64, error) {
+	jitter, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid REFRESH_JITTER %q: %w", s, err)
+	}
+	if jitter < 0 || jitter >= 1 {
+		return 0, fmt.Errorf("REFRESH_JITTER must be at least 0 and less than 1, got %v", jitter)
+	}
+	return jitter, nil
+}
+
+// jitteredDuration returns d shifted by a random amount within ±jitter×d
+func jitteredDuration(d time.Duration, jitter float64) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*jitter*float64(d))
+}
+
+// refresh fetches the issues of all instances and updates the metrics. It returns the number of fetched issues.
+// On error, the metrics of the previous refresh are kept.
+func refresh(ctx context.Context, cfg config) (int, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	started := time.Now()
+	now := cfg.now()
+	// The instances are fetched concurrently, within the GLOBAL_FETCH_CONCURRENCY limit of the requests
+	fetched := make([][]JiraIssue, len(cfg.instances))
+	fetchedGroups := make([]map[string][]JiraIssue, len(cfg.instances))
+	errs := make([]error, len(cfg.instances))
+	var wg sync.WaitGroup
+	for i, instance := range cfg.instances {
+		wg.Add(1)
+		go func(i int, instance config) {
+			defer wg.Done()
+			// The watchdog recovers only the panics of the refresh loop's goroutine, so a panicking fetch fails the refresh
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("Fetch from %s panicked: %v\n%s", instance.jiraURL, r, debug.Stack())
+					errs[i] = fmt.Errorf("%s: panic: %v", instance.jiraURL, r)
+				}
+			}()
+			issues, err := instance.cache.refresh(ctx, instance, now)
 			if err != nil {
-				fmt.Println("Error fetching Jira data:", err)
+				errs[i] = fmt.Errorf("%s: %w", instance.jiraURL, err)
 				return
 			}
-			for _, issue := range issues {
-				transformDataForPrometheus(issue)
+			if err := instance.statusCategories.load(ctx, instance); err != nil {
+				fmt.Printf("Error fetching status categories from %s, category transitions are skipped: %s\n", instance.jiraURL, err)
 			}
-			fmt.Printf("Fetched %d issues in %s\n", len(issues), time.Since(now))
-			time.Sleep(cfg.dataRefreshPeriod)
+			fetched[i] = issues
+			if fetchedGroups[i], err = fetchMetricGroups(ctx, instance); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", instance.jiraURL, err)
+			}
+		}(i, instance)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			consecutiveRefreshFailures.Add(1)
+			return 0, err
 		}
-	}()
+	}
+	issueCollector.Reset()
+	setAnalyzePeriods(cfg, now)
+	total := 0
+	var changelogDuration time.Duration
+	for i, instance := range cfg.instances {
+		changelogDuration += issueCollector.processInstance(instance, fetched[i])
+		setProjectCounts(instance, fetched[i])
+		total += len(fetched[i])
+	}
+	for _, group := range cfg.metricGroups {
+		collector := groupCollectors[group.name]
+		collector.Reset()
+		for i, instance := range cfg.instances {
+			changelogDuration += collector.processInstance(groupConfig(instance, group), fetchedGroups[i][group.name])
+		}
+	}
+	jiraExporterChangelogProcessingDuration.Set(changelogDuration.Seconds())
+	lastSuccessfulRefresh.Store(time.Now().UnixNano())
+	consecutiveRefreshFailures.Store(0)
+	fmt.Printf("Fetched %d issues in %s\n", total, time.Since(started))
+	if cfg.snapshotPath != "" {
+		if err := saveSnapshot(cfg.snapshotPath, cfg, fetched, time.Now()); err != nil {
+			fmt.Printf("Error saving the snapshot: %s\n", err)
+		}
+	}
+	return total, nil
+}
+
+// dryRun prints the JQL queries and a summary of their first pages without starting the server
+func dryRun(ctx context.Context, cfg config, out io.Writer) error {
+	for _, instance := range cfg.instances {
+		for _, window := range queryWindows(instance) {
+			jql := buildJQL(instance, window, instance.now())
+			fmt.Fprintf(out, "JQL on %s: %s\n", instance.jiraURL, jql)
+			issues, err := fetchFirstPage(ctx, instance, jql)
+			if err != nil {
+				return err
+			}
+			keys := make([]string, 0, 3)
+			for i := 0; i < len(issues) && i < cap(keys); i++ {
+				keys = append(keys, issues[i].Key)
+			}
+			fmt.Fprintf(out, "Fetched %d issues on the first page, e.g. %s\n", len(issues), strings.Join(keys, ", "))
+		}
+		for _, group := range instance.metricGroups {
+			fmt.Fprintf(out, "JQL of the metric group %s on %s: %s\n", group.name, instance.jiraURL, group.jql)
+		}
+	}
+	return nil
+}
+
+const day = 24 * time.Hour
 
-	exposeMetrics(cfg)
+// period is the parsed analyze period: either a relative duration or a JQL start function
+type period struct {
+	duration time.Duration
+	function string
 }
 
-func getPeriod(analyzePeriod string) string {
+// getPeriod parses the analyze period. The period is a number of days, a duration like "720h", "30d" or "12w",
+// or one of the functions startOfYear, startOfMonth, startOfWeek, startOfDay.
+func getPeriod(analyzePeriod string) (period, error) {
 	switch analyzePeriod {
+	case "startOfYear", "startOfMonth", "startOfWeek", "startOfDay":
+		return period{function: analyzePeriod}, nil
+	}
+	d, err := parsePeriodDuration(analyzePeriod)
+	if err != nil {
+		return period{}, err
+	}
+	// JQL relative dates have minute precision
+	return period{duration: d.Truncate(time.Minute)}, nil
+}
+
+// jql returns the JQL relative date of the period start, e.g. "-90d" or "startOfMonth()"
+func (p period) jql() string {
+	switch {
+	case p.function != "":
+		return p.function + "()"
+	case p.duration%day == 0:
+		return fmt.Sprintf("-%dd", p.duration/day)
+	case p.duration%time.Hour == 0:
+		return fmt.Sprintf("-%dh", p.duration/time.Hour)
+	default:
+		return fmt.Sprintf("-%dm", p.duration/time.Minute)
+	}
+}
+
+// start returns the time the period starts at, matching the JQL relative date evaluated at now
+func (p period) start(now time.Time) time.Time {
+	year, month, dayOfMonth := now.Date()
+	startOfDay := time.Date(year, month, dayOfMonth, 0, 0, 0, 0, now.Location())
+	switch p.function {
 	case "startOfYear":
-		return fmt.Sprintf("startOfYear()")
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, now.Location())
 	case "startOfMonth":
-		return fmt.Sprintf("startOfMonth()")
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
 	case "startOfWeek":
-		return fmt.Sprintf("startOfWeek()")
+		// Jira weeks start on Sunday
+		return startOfDay.AddDate(0, 0, -int(now.Weekday()))
 	case "startOfDay":
-		return fmt.Sprintf("startOfDay()")
+		return startOfDay
 	default:
-		if toInt(analyzePeriod) > 0 {
-			return fmt.Sprintf("-%dd", toInt(analyzePeriod))
+		return now.Add(-p.duration)
+	}
+}
+
+// parsePeriodDuration parses a plain number of days, a number with the "d" or "w" suffix, or a Go duration
+func parsePeriodDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if days, atoiErr := strconv.Atoi(s); atoiErr == nil {
+		d, err = time.Duration(days)*day, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, atoiErr := strconv.Atoi(strings.TrimSuffix(s, suffix)); strings.HasSuffix(s, suffix) && atoiErr == nil {
+			d, err = time.Duration(n)*unit, nil
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid analyze period %q: %w", s, err)
+	}
+	if d < time.Minute {
+		return 0, fmt.Errorf("invalid analyze period %q: must be at least 1m", s)
+	}
+	return d, nil
+}
+
+// parseSLAThresholds parses the comma-separated status=duration pairs, e.g. "In Review=2d,In Progress=1w".
+// The durations are Go durations or a number of days or weeks with the d or w suffix.
+func parseSLAThresholds(spec string) (map[string]time.Duration, error) {
+	thresholds := make(map[string]time.Duration)
+	for _, item := range parseList(spec) {
+		status, value, found := strings.Cut(item, "=")
+		status = strings.TrimSpace(status)
+		if !found || status == "" {
+			return nil, fmt.Errorf("invalid SLA threshold %q, want status=duration", item)
+		}
+		threshold, err := parseDurationWithDays(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SLA threshold of status %s: %w", status, err)
+		}
+		if threshold <= 0 {
+			return nil, fmt.Errorf("invalid SLA threshold of status %s: must be positive", status)
+		}
+		thresholds[status] = threshold
+	}
+	return thresholds, nil
+}
+
+// parseOpenAgeBuckets parses the comma-separated increasing upper bounds of the open issue age brackets
+func parseOpenAgeBuckets(spec string) ([]time.Duration, error) {
+	bounds := make([]time.Duration, 0)
+	for _, item := range parseList(spec) {
+		bound, err := parseDurationWithDays(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid open age bucket: %w", err)
+		}
+		if bound <= 0 || len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("open age buckets must be positive and increasing, got %q", spec)
+		}
+		bounds = append(bounds, bound)
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("no open age buckets in %q", spec)
+	}
+	return bounds, nil
+}
+
+// parseIssueTypeBuckets parses the semicolon-separated issue type=buckets pairs, e.g. "Bug=1h,1d,1w;Epic=1w,4w,12w".
+// The buckets are increasing Go durations or days and weeks, returned in seconds.
+func parseIssueTypeBuckets(spec string) (map[string][]float64, error) {
+	byIssueType := make(map[string][]float64)
+	for _, item := range strings.Split(spec, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		issueType, value, found := strings.Cut(item, "=")
+		issueType = strings.TrimSpace(issueType)
+		if !found || issueType == "" {
+			return nil, fmt.Errorf("invalid issue type buckets %q, want type=bucket,bucket,...", item)
+		}
+		buckets := make([]float64, 0)
+		for _, bucket := range parseList(value) {
+			d, err := parseDurationWithDays(bucket)
+			if err != nil {
+				return nil, fmt.Errorf("invalid buckets of issue type %s: %w", issueType, err)
+			}
+			if d <= 0 || len(buckets) > 0 && d.Seconds() <= buckets[len(buckets)-1] {
+				return nil, fmt.Errorf("buckets of issue type %s must be positive and increasing, got %q", issueType, value)
+			}
+			buckets = append(buckets, d.Seconds())
+		}
+		if len(buckets) == 0 {
+			return nil, fmt.Errorf("no buckets of issue type %s", issueType)
+		}
+		byIssueType[issueType] = buckets
+	}
+	return byIssueType, nil
+}
+
+// ageBucket returns the label of the bracket of the age, e.g. <1d, 1d-3d or >7d for the bounds 1d,3d,7d.
+// The lower bound is inclusive.
+func ageBucket(age time.Duration, bounds []time.Duration) string {
+	for i, bound := range bounds {
+		if age >= bound {
+			continue
+		}
+		if i == 0 {
+			return "<" + formatDays(bound)
+		}
+		return formatDays(bounds[i-1]) + "-" + formatDays(bound)
+	}
+	return ">" + formatDays(bounds[len(bounds)-1])
+}
+
+// formatDays formats the duration as whole days if possible, without the zero minutes and seconds otherwise,
+// e.g. 3d, 14d or 12h
+func formatDays(d time.Duration) string {
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(d.String(), "0s"), "0m")
+}
+
+// parseDurationWithDays parses a Go duration or a number of days or weeks with the d or w suffix
+func parseDurationWithDays(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); strings.HasSuffix(s, suffix) && err == nil {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseList splits the comma-separated list, trimming spaces and skipping empty items
+func parseList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-		return "-90d"
 	}
+	return items
 }
 
-func toInt(s string) int {
-	i, _ := strconv.Atoi(s)
-	return i
+// lookupEnv returns the value of the env. If <name>_FILE is set, the value is read from that file instead,
+// which allows passing secrets as Docker or Kubernetes secret files. An unset env falls back to CONFIG_FILE,
+// while a set one overrides it even if empty.
+func lookupEnv(name string) string {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return fileSettings[name]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("%s_FILE: %s", name, err))
+	}
+	return strings.TrimRight(string(data), "\r\n")
 }
 
 func getEnvOrDie(name string) string {
-	value := os.Getenv(name)
+	value := lookupEnv(name)
 	if value == "" {
 		panic(fmt.Sprintf("%s env is empty", name))
 	}
@@ -284,7 +2001,7 @@ func getEnvOrDie(name string) string {
 }
 
 func getEnvOrDefault(name string, defaultValue string) string {
-	value := os.Getenv(name)
+	value := lookupEnv(name)
 	if value == "" {
 		return defaultValue
 	}
@@ -298,8 +2015,27 @@ func failOnError(err error) {
 	}
 }
 
+// jiraTimeLayouts is the layouts of the Jira timestamps tried in order. The fractional seconds are optional
+// in all of them, and the zone may be Z, +0000 or +00:00. JIRA_TIME_FORMATS adds the custom ones.
+var jiraTimeLayouts = []string{"2006-01-02T15:04:05Z0700", time.RFC3339}
+
+// parseJiraTime parses the Jira timestamp with the first matching layout
+func parseJiraTime(s string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range jiraTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
 func mustTimeParse(str string) time.Time {
-	t, err := time.Parse(jiraTimeFormat, str)
+	t, err := parseJiraTime(str)
 	if err != nil {
 		panic(err)
 	}
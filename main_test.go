@@ -0,0 +1,2821 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/time/rate"
+)
+
+// registerTestMetrics registers the metrics of the config in a fresh registry for the duration of the test
+func registerTestMetrics(t *testing.T, cfg config) *prometheus.Registry {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registerer, gatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer, prometheus.DefaultGatherer = registry, registry
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer, prometheus.DefaultGatherer = registerer, gatherer
+	})
+	registerMetrics(cfg)
+	return registry
+}
+
+// gatherMetrics returns the series of the metric family, or nil if it has no series
+func gatherMetrics(t *testing.T, registry *prometheus.Registry, name string) []*dto.Metric {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()
+		}
+	}
+	return nil
+}
+
+// findMetric returns the series having all the labels, or nil
+func findMetric(metrics []*dto.Metric, labels map[string]string) *dto.Metric {
+	for _, metric := range metrics {
+		matched := 0
+		for _, pair := range metric.GetLabel() {
+			if value, ok := labels[pair.GetName()]; ok && value == pair.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return metric
+		}
+	}
+	return nil
+}
+
+// parseIssue decodes the issue from the JSON of a search response
+func parseIssue(t *testing.T, data string) JiraIssue {
+	t.Helper()
+	var issue JiraIssue
+	if err := json.Unmarshal([]byte(data), &issue); err != nil {
+		t.Fatal(err)
+	}
+	return issue
+}
+
+func testConfig(t *testing.T) config {
+	t.Helper()
+	cfg := config{analyzePeriod: "90", windowField: "updated", statusCategories: &statusCategories{}}
+	var err error
+	if cfg.projects, err = parseProjects("PROJ", cfg.analyzePeriod); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.countLabels, err = parseCountLabels("", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.fields, err = parseFields("", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.openAgeBuckets, err = parseOpenAgeBuckets("1d,3d,7d"); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMissingPriority(t *testing.T) {
+	cfg := testConfig(t)
+	if !slices.Contains(cfg.fields, "priority") {
+		t.Fatalf("priority is not requested: %v", cfg.fields)
+	}
+	registry := registerTestMetrics(t, cfg)
+	for _, data := range []string{
+		`{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000", "priority": null,
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Epic"}}}`,
+		`{"key": "PROJ-2", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Epic"}},
+			"changelog": {"histories": [{"created": "2024-01-02T10:00:00.000+0000",
+				"items": [{"field": "status", "fromString": "Backlog", "toString": "Open"}]}]}}`,
+		`{"key": "PROJ-3", "fields": {"created": "2024-01-01T10:00:00.000+0000", "priority": {"name": "High"},
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Bug"}}}`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, data))
+	}
+
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if metric := findMetric(counts, map[string]string{"priority": "none"}); metric.GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issue_count{priority=\"none\"} = %v, want 2", metric.GetGauge().GetValue())
+	}
+	if metric := findMetric(counts, map[string]string{"priority": ""}); metric != nil {
+		t.Errorf("unexpected series with an empty priority: %v", metric)
+	}
+	durations := gatherMetrics(t, registry, "jira_issue_time_in_status")
+	if metric := findMetric(durations, map[string]string{"priority": "none"}); metric.GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("jira_issue_time_in_status{priority=\"none\"} count = %d, want 1", metric.GetHistogram().GetSampleCount())
+	}
+}
+
+func TestIsFresh(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		lastSuccess int64
+		want        bool
+	}{
+		{"never refreshed", 0, false},
+		{"fresh", now.Add(-time.Minute).UnixNano(), true},
+		{"at max age", now.Add(-15 * time.Minute).UnixNano(), true},
+		{"stale", now.Add(-16 * time.Minute).UnixNano(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFresh(tt.lastSuccess, 15*time.Minute, now); got != tt.want {
+				t.Errorf("isFresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	previous := lastSuccessfulRefresh.Load()
+	t.Cleanup(func() { lastSuccessfulRefresh.Store(previous) })
+	handler := readinessHandler(config{readinessMaxAge: time.Minute})
+
+	for _, tt := range []struct {
+		name        string
+		lastSuccess int64
+		want        int
+	}{
+		{"never refreshed", 0, http.StatusServiceUnavailable},
+		{"fresh", time.Now().Add(-30 * time.Second).UnixNano(), http.StatusOK},
+		{"stale", time.Now().Add(-2 * time.Minute).UnixNano(), http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lastSuccessfulRefresh.Store(tt.lastSuccess)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartupHandler(t *testing.T) {
+	var calls, failures atomic.Int32
+	failures.Store(1)
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/rest/api/3/myself" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"accountId": "1"}`))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.instances = []config{cfg}
+	registerTestMetrics(t, cfg)
+	handler := startupHandler(cfg)
+
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/startup", nil))
+		if recorder.Code != want {
+			t.Errorf("probe %d: status = %d, want %d", i, recorder.Code, want)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Jira was called %d times, want 2: not again after the successful check", calls.Load())
+	}
+}
+
+func TestCachedCheckCollapsesConcurrentProbes(t *testing.T) {
+	var calls atomic.Int32
+	check := &cachedCheck{
+		ttl:     time.Minute,
+		timeout: time.Second,
+		check: func(ctx context.Context) error {
+			calls.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := check.result(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("check ran %d times within the TTL, want 1", calls.Load())
+	}
+}
+
+func TestCachedCheckIgnoresCallerCancellation(t *testing.T) {
+	check := &cachedCheck{
+		ttl:     time.Minute,
+		timeout: time.Second,
+		check: func(ctx context.Context) error {
+			return ctx.Err()
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := check.result(ctx); err != nil {
+		t.Fatalf("check of a cancelled probe failed: %v", err)
+	}
+	if err := check.result(context.Background()); err != nil {
+		t.Errorf("cached result = %v, want nil", err)
+	}
+}
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unauthorized", &JiraAPIError{StatusCode: http.StatusUnauthorized}, "auth"},
+		{"forbidden", &JiraAPIError{StatusCode: http.StatusForbidden}, "auth"},
+		{"rate limited", &JiraAPIError{StatusCode: http.StatusTooManyRequests}, "ratelimit"},
+		{"server error", &JiraAPIError{StatusCode: http.StatusBadGateway}, "server"},
+		{"bad request", &JiraAPIError{StatusCode: http.StatusBadRequest}, "other"},
+		{"wrapped status", fmt.Errorf("fetch: %w", &JiraAPIError{StatusCode: http.StatusServiceUnavailable}), "server"},
+		{"deadline", context.DeadlineExceeded, "timeout"},
+		{"network timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, "timeout"},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network"},
+		{"syntax", &json.SyntaxError{}, "decode"},
+		{"type", &json.UnmarshalTypeError{}, "decode"},
+		{"truncated body", io.ErrUnexpectedEOF, "decode"},
+		{"unknown", errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFetchError(tt.err); got != tt.want {
+				t.Errorf("classifyFetchError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestGetJSONCountsTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer jira.Close()
+	defer close(release)
+	cfg := testConfig(t)
+	cfg.client = &http.Client{Timeout: 50 * time.Millisecond}
+	registry := registerTestMetrics(t, cfg)
+
+	if err := getJSON(context.Background(), cfg, jira.URL, &struct{}{}); err == nil {
+		t.Fatal("getJSON() of a hung request succeeded")
+	}
+	errs := gatherMetrics(t, registry, "jira_fetch_errors_total")
+	if metric := findMetric(errs, map[string]string{"cause": "timeout"}); metric.GetCounter().GetValue() != 1 {
+		t.Errorf("jira_fetch_errors_total{cause=\"timeout\"} = %v, want 1", metric.GetCounter().GetValue())
+	}
+}
+
+func TestParseProjects(t *testing.T) {
+	windows, err := parseProjects("PROJ1:30, PROJ2:startOfMonth,PROJ3,PROJ4:30d", "90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"updated >= -30d AND project in (PROJ1,PROJ4)",
+		`updated >= "2024/03/01 00:00" AND project in (PROJ2)`,
+		"updated >= -90d AND project in (PROJ3)",
+	}
+	got := make([]string, 0, len(windows))
+	for _, window := range windows {
+		got = append(got, buildJQL(config{windowField: "updated"}, window, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("JQLs = %q, want %q", got, want)
+	}
+}
+
+func TestParseProjectsErrors(t *testing.T) {
+	for _, spec := range []string{"", " , ", "PROJ1:abc", "PROJ1:0"} {
+		if _, err := parseProjects(spec, "90"); err == nil {
+			t.Errorf("parseProjects(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestStoryPoints(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.storyPointsField = "customfield_10016"
+	if fields := searchFields(cfg); !strings.HasSuffix(fields, ",customfield_10016") {
+		t.Errorf("searchFields() = %q, want the story points field", fields)
+	}
+	registry := registerTestMetrics(t, cfg)
+	for _, points := range []string{`3`, `"5.5"`, `null`, `"large"`, `{"value": 8}`, ``} {
+		field := ""
+		if points != "" {
+			field = `"customfield_10016": ` + points + `,`
+		}
+		issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {`+field+`
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Story"}}}`))
+	}
+	points := gatherMetrics(t, registry, "jira_issue_story_points")
+	if metric := findMetric(points, map[string]string{"project": "PROJ", "status": "Open"}); metric.GetGauge().GetValue() != 8.5 {
+		t.Errorf("jira_issue_story_points = %v, want 8.5", metric.GetGauge().GetValue())
+	}
+}
+
+func TestStoryPointsWithoutField(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {"customfield_10016": 3,
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Story"}}}`))
+	if points := gatherMetrics(t, registry, "jira_issue_story_points"); len(points) != 0 {
+		t.Errorf("jira_issue_story_points has %d series without JIRA_STORY_POINTS_FIELD, want 0", len(points))
+	}
+}
+
+func TestGetPeriod(t *testing.T) {
+	tests := []struct {
+		analyzePeriod string
+		wantJQL       string
+	}{
+		{"90", "-90d"},
+		{"30d", "-30d"},
+		{"2w", "-14d"},
+		{"720h", "-30d"},
+		{"36h", "-36h"},
+		{"90m", "-90m"},
+		{"90m30s", "-90m"},
+		{"startOfMonth", "startOfMonth()"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.analyzePeriod, func(t *testing.T) {
+			p, err := getPeriod(tt.analyzePeriod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.jql(); got != tt.wantJQL {
+				t.Errorf("jql() = %q, want %q", got, tt.wantJQL)
+			}
+		})
+	}
+}
+
+func TestGetPeriodErrors(t *testing.T) {
+	for _, analyzePeriod := range []string{"", "abc", "-5", "0", "30s", "10x", "startOfCentury"} {
+		if _, err := getPeriod(analyzePeriod); err == nil {
+			t.Errorf("getPeriod(%q) succeeded, want an error", analyzePeriod)
+		}
+	}
+}
+
+// testIssueJSON returns a minimal issue of the search response
+func testIssueJSON(key string) string {
+	return fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"updated": "2024-01-02T10:00:00.000+0000", "status": {"name": "Open"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`, key)
+}
+
+func TestDryRun(t *testing.T) {
+	var requests atomic.Int32
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if startAt := r.URL.Query().Get("startAt"); startAt != "0" {
+			t.Errorf("dry run fetched startAt=%s, want only the first page", startAt)
+		}
+		fmt.Fprintf(w, `{"issues": [%s, %s, %s, %s]}`,
+			testIssueJSON("PROJ-1"), testIssueJSON("PROJ-2"), testIssueJSON("PROJ-3"), testIssueJSON("PROJ-4"))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.instances = []config{cfg}
+	registerTestMetrics(t, cfg)
+
+	var out strings.Builder
+	if err := dryRun(context.Background(), cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "JQL on " + jira.URL + ": updated >= -90d AND project in (PROJ)\n" +
+		"Fetched 4 issues on the first page, e.g. PROJ-1, PROJ-2, PROJ-3\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("dry run made %d requests, want 1", requests.Load())
+	}
+}
+
+func TestOpenIssueAge(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	minAge := time.Since(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)).Seconds()
+	for _, data := range []string{
+		`{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000", "project": {"key": "PROJ"},
+			"status": {"name": "Open", "statusCategory": {"key": "new", "name": "To Do"}}, "issuetype": {"name": "Task"}}}`,
+		`{"key": "PROJ-2", "fields": {"created": "2024-01-01T10:00:00.000+0000", "project": {"key": "PROJ"},
+			"status": {"name": "In Progress", "statusCategory": {"key": "indeterminate", "name": "In Progress"}}, "issuetype": {"name": "Task"}}}`,
+		`{"key": "PROJ-3", "fields": {"created": "2024-01-01T10:00:00.000+0000", "project": {"key": "PROJ"},
+			"status": {"name": "Closed", "statusCategory": {"key": "done", "name": "Done"}}, "issuetype": {"name": "Task"}}}`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, data))
+	}
+	ages := gatherMetrics(t, registry, "jira_open_issue_age_seconds")
+	if len(ages) != 2 {
+		t.Fatalf("jira_open_issue_age_seconds has %d series, want 2", len(ages))
+	}
+	if metric := findMetric(ages, map[string]string{"status": "Closed"}); metric != nil {
+		t.Error("a Done issue contributes to jira_open_issue_age_seconds")
+	}
+	for _, status := range []string{"Open", "In Progress"} {
+		histogram := findMetric(ages, map[string]string{"status": status}).GetHistogram()
+		if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() < minAge {
+			t.Errorf("%s: count = %d, sum = %v, want 1 observation of at least %v", status,
+				histogram.GetSampleCount(), histogram.GetSampleSum(), minAge)
+		}
+	}
+}
+
+func TestOpenIssueAgeLocalized(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	for _, data := range []string{
+		`{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000", "project": {"key": "PROJ"},
+			"status": {"name": "Ouvert", "statusCategory": {"key": "new", "name": "À faire"}}, "issuetype": {"name": "Tâche"}}}`,
+		`{"key": "PROJ-2", "fields": {"created": "2024-01-01T10:00:00.000+0000", "project": {"key": "PROJ"},
+			"status": {"name": "Fermé", "statusCategory": {"key": "done", "name": "Terminé"}}, "issuetype": {"name": "Tâche"}}}`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, data))
+	}
+	ages := gatherMetrics(t, registry, "jira_open_issue_age_seconds")
+	if len(ages) != 1 || findMetric(ages, map[string]string{"status": "Ouvert"}) == nil {
+		t.Errorf("jira_open_issue_age_seconds = %v, want only the issue not in the done category", ages)
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if findMetric(counts, map[string]string{"status": "Fermé", "statusCategory": "Terminé"}) == nil {
+		t.Errorf("jira_issue_count = %v, want the localized category name in the label", counts)
+	}
+}
+
+func TestDedupIssues(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	first := parseIssue(t, testIssueJSON("PROJ-1"))
+	duplicate := first
+	duplicate.Fields.Status.Name = "Closed"
+	issues := dedupIssues([]JiraIssue{first, parseIssue(t, testIssueJSON("PROJ-2")), duplicate})
+	if len(issues) != 2 {
+		t.Fatalf("dedupIssues() returned %d issues, want 2", len(issues))
+	}
+	for _, issue := range issues {
+		issueCollector.processIssue(cfg, issue)
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if metric := findMetric(counts, map[string]string{"status": "Open"}); metric.GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issue_count{status=\"Open\"} = %v, want 2", metric.GetGauge().GetValue())
+	}
+	if metric := findMetric(counts, map[string]string{"status": "Closed"}); metric != nil {
+		t.Error("the duplicate replaced the first occurrence")
+	}
+}
+
+// testIssueWithTransitionJSON returns an issue that moved from Open to Done a day after creation
+func testIssueWithTransitionJSON(key string) string {
+	return fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"updated": "2024-01-02T10:00:00.000+0000", "project": {"key": "PROJ"}, "issuetype": {"name": "Task"},
+		"status": {"name": "Done", "statusCategory": {"key": "done", "name": "Done"}}},
+		"changelog": {"total": 1, "histories": [{"created": "2024-01-02T10:00:00.000+0000",
+			"items": [{"field": "status", "from": "1", "fromString": "Open", "to": "3", "toString": "Done"}]}]}}`, key)
+}
+
+// exemplarKeys returns the issueKey labels of the exemplars of the histogram buckets
+func exemplarKeys(metric *dto.Metric) []string {
+	keys := make([]string, 0)
+	for _, bucket := range metric.GetHistogram().GetBucket() {
+		for _, pair := range bucket.GetExemplar().GetLabel() {
+			if pair.GetName() == "issueKey" {
+				keys = append(keys, pair.GetValue())
+			}
+		}
+	}
+	return keys
+}
+
+func TestExemplars(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.enableExemplars = enabled
+			registry := registerTestMetrics(t, cfg)
+			issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-7")))
+
+			durations := gatherMetrics(t, registry, "jira_issue_time_in_status")
+			if len(durations) != 1 {
+				t.Fatalf("jira_issue_time_in_status has %d series, want 1", len(durations))
+			}
+			keys := exemplarKeys(durations[0])
+			if enabled && !slices.Equal(keys, []string{"PROJ-7"}) {
+				t.Errorf("exemplar issue keys = %q, want [PROJ-7]", keys)
+			}
+			if !enabled && len(keys) != 0 {
+				t.Errorf("exemplar issue keys = %q with exemplars disabled", keys)
+			}
+		})
+	}
+}
+
+func TestOAuthToken(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		expiresIn     int
+		wantTokenReqs int32
+	}{
+		{"reused until expiry", 3600, 1},
+		// The client refreshes tokens that expire within 10 seconds
+		{"refreshed before expiry", 5, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokenRequests atomic.Int32
+			tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" {
+					t.Errorf("unexpected token request %v", r.Form)
+				}
+				if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+					t.Errorf("token request credentials = %s:%s", id, secret)
+				}
+				n := tokenRequests.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": %d}`, n, tt.expiresIn)
+			}))
+			defer tokens.Close()
+			var authorizations []string
+			jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorizations = append(authorizations, r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer jira.Close()
+			cfg := testConfig(t)
+			cfg.oauth = &clientcredentials.Config{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "secret"}
+			cfg.client = cfg.oauth.Client(context.Background())
+			registerTestMetrics(t, cfg)
+
+			for i := 0; i < 2; i++ {
+				if err := getJSON(context.Background(), cfg, jira.URL, &struct{}{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tokenRequests.Load() != tt.wantTokenReqs {
+				t.Errorf("token requests = %d, want %d", tokenRequests.Load(), tt.wantTokenReqs)
+			}
+			if authorizations[0] != "Bearer token1" {
+				t.Errorf("Authorization = %q, want the bearer token", authorizations[0])
+			}
+		})
+	}
+}
+
+func TestIssuesCreated(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.projects, err = parseProjects("PROJ,OTHER:7", "90"); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	for i, fixture := range []struct {
+		project string
+		age     time.Duration
+	}{
+		{"PROJ", time.Hour},
+		{"PROJ", 89 * day},
+		{"PROJ", 91 * day},
+		{"OTHER", 6 * day},
+		{"OTHER", 8 * day},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "%s-%d", "fields": {"created": %q,
+			"status": {"name": "Open"}, "project": {"key": %q}, "issuetype": {"name": "Task"}}}`,
+			fixture.project, i, now.Add(-fixture.age).Format(jiraTimeFormat), fixture.project)))
+	}
+	created := gatherMetrics(t, registry, "jira_issues_created_total")
+	for project, want := range map[string]float64{"PROJ": 2, "OTHER": 1} {
+		if metric := findMetric(created, map[string]string{"project": project}); metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_issues_created_total{project=%q} = %v, want %v", project, metric.GetGauge().GetValue(), want)
+		}
+	}
+}
+
+func TestIssuesResolved(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.projects, err = parseProjects("PROJ,OTHER:7", "90"); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	for i, fixture := range []struct {
+		project  string
+		resolved string
+	}{
+		{"PROJ", now.Add(-time.Hour).Format(jiraTimeFormat)},
+		{"PROJ", now.Add(-89 * day).Format(jiraTimeFormat)},
+		{"PROJ", now.Add(-91 * day).Format(jiraTimeFormat)},
+		{"PROJ", ""},
+		{"OTHER", now.Add(-6 * day).Format(jiraTimeFormat)},
+		{"OTHER", now.Add(-8 * day).Format(jiraTimeFormat)},
+		{"EMPTY", ""},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "%s-%d", "fields": {"created": "2020-01-01T10:00:00.000+0000",
+			"resolutiondate": %q, "status": {"name": "Done"}, "project": {"key": %q}, "issuetype": {"name": "Task"}}}`,
+			fixture.project, i, fixture.resolved, fixture.project)))
+	}
+	resolved := gatherMetrics(t, registry, "jira_issues_resolved_total")
+	if len(resolved) != 2 {
+		t.Errorf("jira_issues_resolved_total has %d series, want none for the project without resolutions", len(resolved))
+	}
+	for project, want := range map[string]float64{"PROJ": 2, "OTHER": 1} {
+		if metric := findMetric(resolved, map[string]string{"project": project}); metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_issues_resolved_total{project=%q} = %v, want %v", project, metric.GetGauge().GetValue(), want)
+		}
+	}
+}
+
+func TestWindowStartMatchesJQL(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	// "updated >= -90d" includes the issues at exactly 90 days ago
+	if got, want := windowStart(cfg, "proj", now), now.Add(-90*day); !got.Equal(want) {
+		t.Errorf("windowStart() = %s, want %s", got, want)
+	}
+	if got := windowStart(cfg, "UNKNOWN", now); !got.IsZero() {
+		t.Errorf("windowStart() of an unknown project = %s, want zero", got)
+	}
+}
+
+func TestFetchCancellation(t *testing.T) {
+	received := make(chan struct{})
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startAt") == "0" {
+			fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+			return
+		}
+		// Hang on the second page until the client gives up
+		close(received)
+		<-r.Context().Done()
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registerTestMetrics(t, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	if _, err := fetchJiraData(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchJiraData() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestTruncatedChangelog(t *testing.T) {
+	history := func(created string) string {
+		return fmt.Sprintf(`{"created": "2024-01-0%sT10:00:00.000+0000", "items": [{"field": "status"}]}`, created)
+	}
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rest/api/3/search" && r.URL.Query().Get("startAt") == "0":
+			// The search response has only the newest history
+			fmt.Fprintf(w, `{"issues": [{"key": "PROJ-1", "changelog": {"maxResults": 1, "total": 3, "histories": [%s]}},
+				{"key": "PROJ-2", "changelog": {"maxResults": 1, "total": 1, "histories": [%s]}}]}`, history("4"), history("5"))
+		case r.URL.Path == "/rest/api/3/search":
+			fmt.Fprint(w, `{"issues": []}`)
+		case r.URL.Path == "/rest/api/3/issue/PROJ-1/changelog" && r.URL.Query().Get("startAt") == "0":
+			fmt.Fprintf(w, `{"isLast": false, "values": [%s, %s]}`, history("2"), history("3"))
+		case r.URL.Path == "/rest/api/3/issue/PROJ-1/changelog" && r.URL.Query().Get("startAt") == "2":
+			fmt.Fprintf(w, `{"isLast": true, "values": [%s]}`, history("4"))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := make([]string, 0)
+	for _, history := range issues[0].Changelog.Histories {
+		created = append(created, history.Created[:10])
+	}
+	if want := []string{"2024-01-04", "2024-01-03", "2024-01-02"}; !slices.Equal(created, want) {
+		t.Errorf("histories = %q, want the complete changelog newest first %q", created, want)
+	}
+	if len(issues[1].Changelog.Histories) != 1 {
+		t.Errorf("complete changelog has %d histories, want 1", len(issues[1].Changelog.Histories))
+	}
+	truncated := gatherMetrics(t, registry, "jira_issue_changelog_truncated_total")
+	if len(truncated) != 1 || truncated[0].GetCounter().GetValue() != 1 {
+		t.Errorf("jira_issue_changelog_truncated_total = %v, want 1", truncated)
+	}
+}
+
+func TestChangelogExpandWindow(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([]string, 0)
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/rest/api/3/search" && r.URL.Query().Has("expand"):
+			t.Errorf("the search expands %q, want no expansion", r.URL.Query().Get("expand"))
+			w.WriteHeader(http.StatusBadRequest)
+		case r.URL.Path == "/rest/api/3/search" && r.URL.Query().Get("startAt") == "0":
+			fmt.Fprint(w, `{"issues": [{"key": "PROJ-1", "fields": {"updated": "2024-03-09T10:00:00.000+0000"}},
+				{"key": "PROJ-2", "fields": {"updated": "2024-02-01T10:00:00.000+0000"}},
+				{"key": "PROJ-3", "fields": {"updated": "2024-03-10T09:00:00.000+0000"}}]}`)
+		case r.URL.Path == "/rest/api/3/search":
+			fmt.Fprint(w, `{"issues": []}`)
+		case r.URL.Path == "/rest/api/3/issue/PROJ-1/changelog", r.URL.Path == "/rest/api/3/issue/PROJ-3/changelog":
+			fmt.Fprint(w, `{"isLast": true, "values": [{"created": "2024-03-01T10:00:00.000+0000", "items": [{"field": "status"}]},
+				{"created": "2024-03-02T10:00:00.000+0000", "items": [{"field": "status"}]}]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.clock = fixedClock(time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC))
+	cfg.changelogExpandWindow = 7 * day
+	registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/rest/api/3/search", "/rest/api/3/search",
+		"/rest/api/3/issue/PROJ-1/changelog", "/rest/api/3/issue/PROJ-3/changelog"}
+	if !slices.Equal(requests, want) {
+		t.Errorf("requests = %q, want the search and the changelogs of the recently updated issues %q", requests, want)
+	}
+	for i, histories := range []int{2, 0, 2} {
+		if got := len(issues[i].Changelog.Histories); got != histories {
+			t.Errorf("%s has %d histories, want %d", issues[i].Key, got, histories)
+		}
+	}
+	// The histories are newest first, as in the search response
+	if created := issues[0].Changelog.Histories[0].Created; created[:10] != "2024-03-02" {
+		t.Errorf("the first history of PROJ-1 is created at %s, want the newest one", created)
+	}
+}
+
+func TestIssueTypeFilter(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.includeIssueTypes = []string{"Story", "Bug", "Sub-task"}
+	cfg.excludeIssueTypes = []string{"sub-task", "Epic"}
+	registry := registerTestMetrics(t, cfg)
+	for i, issueType := range []string{"Story", "bug", "Sub-task", "Epic", "Task"} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": %q}}}`, i, issueType)))
+	}
+	got := make([]string, 0)
+	for _, metric := range gatherMetrics(t, registry, "jira_issue_count") {
+		for _, pair := range metric.GetLabel() {
+			if pair.GetName() == "issueType" {
+				got = append(got, pair.GetValue())
+			}
+		}
+	}
+	slices.Sort(got)
+	if want := []string{"Story", "bug"}; !slices.Equal(got, want) {
+		t.Errorf("issue types = %q, want %q", got, want)
+	}
+}
+
+func TestTimeToFirstTransition(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Done"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Bug"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress"}]},
+			{"created": "2024-01-01T12:00:00.000+0000", "items": [{"field": "assignee"}]},
+			{"created": "2024-01-01T13:30:00.000+0000", "items": [{"field": "status", "fromString": "Open"}]}]}}`))
+	// Not transitioned yet
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-2", "fields": {
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [{"created": "2024-01-01T12:00:00.000+0000", "items": [{"field": "assignee"}]}]}}`))
+
+	transitions := gatherMetrics(t, registry, "jira_issue_time_to_first_transition_seconds")
+	if len(transitions) != 1 {
+		t.Fatalf("jira_issue_time_to_first_transition_seconds has %d series, want 1", len(transitions))
+	}
+	histogram := findMetric(transitions, map[string]string{"project": "PROJ", "issueType": "Bug"}).GetHistogram()
+	if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != (3*time.Hour+30*time.Minute).Seconds() {
+		t.Errorf("count = %d, sum = %v, want a single 3h30m observation", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestWindowInTimeZone(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.location, err = time.LoadLocation("America/New_York"); err != nil {
+		t.Fatal(err)
+	}
+	// It's still February 29 in New York
+	now := time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		analyzePeriod string
+		wantJQL       string
+		wantStart     time.Time
+	}{
+		{"startOfDay", `updated >= "2024/02/29 00:00" AND project in (PROJ)`, time.Date(2024, 2, 29, 5, 0, 0, 0, time.UTC)},
+		{"startOfMonth", `updated >= "2024/02/01 00:00" AND project in (PROJ)`, time.Date(2024, 2, 1, 5, 0, 0, 0, time.UTC)},
+		{"startOfWeek", `updated >= "2024/02/25 00:00" AND project in (PROJ)`, time.Date(2024, 2, 25, 5, 0, 0, 0, time.UTC)},
+		{"startOfYear", `updated >= "2024/01/01 00:00" AND project in (PROJ)`, time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)},
+		{"2d", "updated >= -2d AND project in (PROJ)", now.Add(-2 * day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.analyzePeriod, func(t *testing.T) {
+			if cfg.projects, err = parseProjects("PROJ", tt.analyzePeriod); err != nil {
+				t.Fatal(err)
+			}
+			if got := buildJQL(cfg, cfg.projects[0], now); got != tt.wantJQL {
+				t.Errorf("buildJQL() = %s, want %s", got, tt.wantJQL)
+			}
+			if got := windowStart(cfg, "PROJ", now); !got.Equal(tt.wantStart) {
+				t.Errorf("windowStart() = %s, want %s", got, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestTokenPagination(t *testing.T) {
+	pages := map[string]string{
+		"":       fmt.Sprintf(`{"issues": [%s], "nextPageToken": "page2"}`, testIssueJSON("PROJ-1")),
+		"page2":  fmt.Sprintf(`{"issues": [%s, %s], "nextPageToken": "page3"}`, testIssueJSON("PROJ-2"), testIssueJSON("PROJ-3")),
+		"page3":  fmt.Sprintf(`{"issues": [%s]}`, testIssueJSON("PROJ-4")),
+		"unused": `{"issues": []}`,
+	}
+	var tokens []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/3/search/jql" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		token := r.URL.Query().Get("nextPageToken")
+		tokens = append(tokens, token)
+		_, _ = w.Write([]byte(pages[token]))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.tokenPagination = true
+	registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		keys = append(keys, issue.Key)
+	}
+	if want := []string{"PROJ-1", "PROJ-2", "PROJ-3", "PROJ-4"}; !slices.Equal(keys, want) {
+		t.Errorf("issues = %q, want %q", keys, want)
+	}
+	if want := []string{"", "page2", "page3"}; !slices.Equal(tokens, want) {
+		t.Errorf("page tokens = %q, want %q", tokens, want)
+	}
+}
+
+func TestStatusSummary(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.enableStatusSummary = true
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-1")))
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-2")))
+
+	histograms := gatherMetrics(t, registry, "jira_issue_time_in_status")
+	summaries := gatherMetrics(t, registry, "jira_issue_time_in_status_summary")
+	if len(histograms) != 1 || len(summaries) != 1 {
+		t.Fatalf("got %d histogram and %d summary series, want 1 each", len(histograms), len(summaries))
+	}
+	histogram, summary := histograms[0].GetHistogram(), summaries[0].GetSummary()
+	if summary.GetSampleCount() != histogram.GetSampleCount() || summary.GetSampleSum() != histogram.GetSampleSum() {
+		t.Errorf("summary count = %d, sum = %v, want the histogram's %d and %v", summary.GetSampleCount(),
+			summary.GetSampleSum(), histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+	for _, quantile := range summary.GetQuantile() {
+		if quantile.GetValue() != day.Seconds() {
+			t.Errorf("quantile %v = %v, want %v", quantile.GetQuantile(), quantile.GetValue(), day.Seconds())
+		}
+	}
+}
+
+func TestStatusSummaryDisabled(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-1")))
+	if summaries := gatherMetrics(t, registry, "jira_issue_time_in_status_summary"); summaries != nil {
+		t.Error("the summary is exported without ENABLE_STATUS_SUMMARY")
+	}
+}
+
+func TestClampPeriods(t *testing.T) {
+	tests := []struct {
+		spec        string
+		wantJQL     string
+		wantClamped bool
+	}{
+		{"PROJ:365", "updated >= -365d AND project in (PROJ)", false},
+		{"PROJ:366", "updated >= -365d AND project in (PROJ)", true},
+		{"PROJ:36500", "updated >= -365d AND project in (PROJ)", true},
+		{"PROJ:8759h", "updated >= -8759h AND project in (PROJ)", false},
+		{"PROJ:startOfYear", `updated >= "2024/01/01 00:00" AND project in (PROJ)`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			windows, err := parseProjects(tt.spec, "90")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clamped := clampPeriods(windows, 365*day); clamped != tt.wantClamped {
+				t.Errorf("clampPeriods() = %v, want %v", clamped, tt.wantClamped)
+			}
+			if got := buildJQL(config{windowField: "updated"}, windows[0], time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)); got != tt.wantJQL {
+				t.Errorf("JQL = %q, want %q", got, tt.wantJQL)
+			}
+		})
+	}
+}
+
+func TestWindowClampedMetric(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.windowClamped = true
+	registry := registerTestMetrics(t, cfg)
+	clamped := gatherMetrics(t, registry, "jira_exporter_window_clamped")
+	if len(clamped) != 1 || clamped[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_exporter_window_clamped = %v, want 1", clamped)
+	}
+}
+
+func TestRefreshLoopRestartsAfterPanic(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.dataRefreshPeriod = time.Millisecond
+	registry := registerTestMetrics(t, cfg)
+	previous := refreshRestartBackoff
+	refreshRestartBackoff = time.Millisecond
+	t.Cleanup(func() { refreshRestartBackoff = previous })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	superviseRefreshLoop(ctx, cfg, func(ctx context.Context) error {
+		calls++
+		switch calls {
+		case 1:
+			return nil
+		case 2:
+			panic("unexpected issue data")
+		}
+		cancel()
+		return nil
+	})
+	if calls != 3 {
+		t.Errorf("refresh ran %d times, want the loop to resume after the panic", calls)
+	}
+	restarts := gatherMetrics(t, registry, "jira_exporter_refresh_restarts_total")
+	if len(restarts) != 1 || restarts[0].GetCounter().GetValue() != 1 {
+		t.Errorf("jira_exporter_refresh_restarts_total = %v, want 1", restarts)
+	}
+}
+
+func TestRefreshRecoversFetchPanic(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.dataRefreshPeriod = time.Millisecond
+	// Without an HTTP client, the fetch panics in its own goroutine
+	cfg.jiraURL = "http://jira.invalid"
+	cfg.cache = &issueCache{}
+	cfg.instances = []config{cfg}
+	registry := registerTestMetrics(t, cfg)
+	failures := consecutiveRefreshFailures.Load()
+	t.Cleanup(func() { consecutiveRefreshFailures.Store(failures) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var refreshErr error
+	superviseRefreshLoop(ctx, cfg, func(ctx context.Context) error {
+		_, refreshErr = refresh(ctx, cfg)
+		cancel()
+		return refreshErr
+	})
+	if refreshErr == nil || !strings.Contains(refreshErr.Error(), "panic") {
+		t.Errorf("refresh() = %v, want the error of the panic", refreshErr)
+	}
+	if got := consecutiveRefreshFailures.Load(); got != failures+1 {
+		t.Errorf("consecutive refresh failures = %d, want %d", got, failures+1)
+	}
+	errs := gatherMetrics(t, registry, "jira_exporter_scrape_errors_total")
+	if len(errs) != 1 || errs[0].GetCounter().GetValue() != 1 {
+		t.Errorf("jira_exporter_scrape_errors_total = %v, want 1", errs)
+	}
+}
+
+func TestRefreshLoopRecovers(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.dataRefreshPeriod = time.Millisecond
+	registry := registerTestMetrics(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	refreshLoop(ctx, cfg, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("jira is down")
+		}
+		cancel()
+		return nil
+	})
+	if calls != 2 {
+		t.Errorf("refresh ran %d times, want a retry after the failure", calls)
+	}
+	errs := gatherMetrics(t, registry, "jira_exporter_scrape_errors_total")
+	if len(errs) != 1 || errs[0].GetCounter().GetValue() != 1 {
+		t.Errorf("jira_exporter_scrape_errors_total = %v, want 1", errs)
+	}
+}
+
+func TestRefreshIterations(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.dataRefreshPeriod = 20 * time.Millisecond
+	registry := registerTestMetrics(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	refreshLoop(ctx, cfg, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			// A long refresh delays the next one by its duration
+			time.Sleep(30 * time.Millisecond)
+			return nil
+		}
+		cancel()
+		return nil
+	})
+	iterations := gatherMetrics(t, registry, "jira_exporter_refresh_iterations_total")
+	if len(iterations) != 1 || iterations[0].GetCounter().GetValue() != 2 {
+		t.Errorf("jira_exporter_refresh_iterations_total = %v, want 2", iterations)
+	}
+	skew := gatherMetrics(t, registry, "jira_exporter_refresh_interval_skew_seconds")
+	if len(skew) != 1 {
+		t.Fatalf("jira_exporter_refresh_interval_skew_seconds has %d series, want 1", len(skew))
+	}
+	if got := skew[0].GetGauge().GetValue(); got < 0.03 || got > 1 {
+		t.Errorf("jira_exporter_refresh_interval_skew_seconds = %v, want the 30ms of the long refresh", got)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	for i, labels := range []string{`["payments", "auth"]`, `["payments"]`, `[]`, `null`} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {"labels": %s,
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`, i, labels)))
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_label_count")
+	if len(counts) != 2 {
+		t.Errorf("jira_issue_label_count has %d series, want 2", len(counts))
+	}
+	for label, want := range map[string]float64{"payments": 2, "auth": 1} {
+		if metric := findMetric(counts, map[string]string{"project": "PROJ", "label": label}); metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_issue_label_count{label=%q} = %v, want %v", label, metric.GetGauge().GetValue(), want)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgents []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.userAgent = "jira-issues-exporter/1.2.3"
+	registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(userAgents) == 0 || userAgents[0] != cfg.userAgent {
+		t.Errorf("User-Agent = %q, want %q", userAgents, cfg.userAgent)
+	}
+}
+
+func TestDataQualityIssues(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	for _, data := range []string{
+		// Complete
+		`{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000", "assignee": {"emailAddress": "a@example.com"},
+			"priority": {"name": "High"}, "status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+		// Unassigned, without priority
+		`{"key": "PROJ-2", "fields": {"created": "2024-01-01T10:00:00.000+0000", "assignee": null,
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+		// Bad creation time, no status
+		`{"key": "PROJ-3", "fields": {"created": "yesterday", "assignee": {"emailAddress": "a@example.com"},
+			"priority": {"name": "High"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+		// Bad changelog time
+		`{"key": "PROJ-4", "fields": {"created": "2024-01-01T10:00:00.000+0000", "assignee": {"emailAddress": "a@example.com"},
+			"priority": {"name": "High"}, "status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+			"changelog": {"histories": [{"created": "2024-01-02", "items": [{"field": "status", "fromString": "New"}]}]}}`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, data))
+	}
+	issues := gatherMetrics(t, registry, "jira_issue_data_quality_issues_total")
+	want := map[string]float64{"no_assignee": 1, "no_priority": 1, "bad_timestamp": 2, "no_status": 1}
+	if len(issues) != len(want) {
+		t.Errorf("jira_issue_data_quality_issues_total has %d series, want %d", len(issues), len(want))
+	}
+	for kind, count := range want {
+		if metric := findMetric(issues, map[string]string{"kind": kind}); metric.GetGauge().GetValue() != count {
+			t.Errorf("jira_issue_data_quality_issues_total{kind=%q} = %v, want %v", kind, metric.GetGauge().GetValue(), count)
+		}
+	}
+}
+
+func TestLookupEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_PLAIN", "from-env")
+	t.Setenv("TEST_SECRET", "from-env")
+	t.Setenv("TEST_SECRET_FILE", secret)
+
+	if got := lookupEnv("TEST_PLAIN"); got != "from-env" {
+		t.Errorf("lookupEnv(TEST_PLAIN) = %q, want from-env", got)
+	}
+	if got := lookupEnv("TEST_SECRET"); got != "from-file" {
+		t.Errorf("lookupEnv(TEST_SECRET) = %q, want the file to take precedence", got)
+	}
+	if got := getEnvOrDefault("TEST_UNSET", "default"); got != "default" {
+		t.Errorf("getEnvOrDefault(TEST_UNSET) = %q, want default", got)
+	}
+}
+
+func TestLookupEnvMissingFile(t *testing.T) {
+	t.Setenv("TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	defer func() {
+		if recover() == nil {
+			t.Error("lookupEnv() with a missing file didn't fail")
+		}
+	}()
+	lookupEnv("TEST_SECRET")
+}
+
+func TestChangelogEntries(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	history := `{"created": "2024-01-02T10:00:00.000+0000", "items": [{"field": "assignee"}]}`
+	for i, histories := range [][]string{nil, {history}, {history, history, history}} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"}, "project": {"key": "PROJ"},
+			"issuetype": {"name": "Task"}}, "changelog": {"histories": [%s]}}`, i, strings.Join(histories, ","))))
+	}
+	entries := gatherMetrics(t, registry, "jira_issue_changelog_entries")
+	histogram := findMetric(entries, map[string]string{"project": "PROJ"}).GetHistogram()
+	if histogram.GetSampleCount() != 3 || histogram.GetSampleSum() != 4 {
+		t.Errorf("count = %d, sum = %v, want 3 issues with 0+1+3 entries", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("created, status,project,issuetype,labels", config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{fields: fields, storyPointsField: "customfield_10016"}
+	if got, want := searchFields(cfg), "created,status,project,issuetype,labels,customfield_10016"; got != want {
+		t.Errorf("searchFields() = %q, want %q", got, want)
+	}
+	for _, tt := range []struct {
+		spec string
+		cfg  config
+	}{
+		{"created,status,project", config{}},
+		{"status,project,issuetype", config{}},
+		{"created,status,project,issuetype", config{fullRefreshInterval: time.Hour}},
+		{"created,status,project,issuetype", config{changelogExpandWindow: 7 * day}},
+	} {
+		if _, err := parseFields(tt.spec, tt.cfg); err == nil {
+			t.Errorf("parseFields(%q) succeeded, want a missing required field error", tt.spec)
+		}
+	}
+	if _, err := parseFields("created,updated,status,project,issuetype", config{changelogExpandWindow: 7 * day}); err != nil {
+		t.Errorf("parseFields() with the update times and CHANGELOG_EXPAND_WINDOW = %v, want no error", err)
+	}
+}
+
+func TestFieldsInSearchURL(t *testing.T) {
+	var fields []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields = append(fields, r.URL.Query().Get("fields"))
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.fields = []string{"created", "status", "project", "issuetype"}
+	registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) == 0 || fields[0] != "created,status,project,issuetype" {
+		t.Errorf("fields = %q, want the configured ones", fields)
+	}
+}
+
+func TestDataQualityOfUnrequestedFields(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.fields = []string{"created", "status", "project", "issuetype"}
+	issue := parseIssue(t, testIssueJSON("PROJ-1"))
+	if kinds := dataQualityIssues(cfg, issue); len(kinds) != 0 {
+		t.Errorf("dataQualityIssues() = %q for the fields that weren't requested", kinds)
+	}
+}
+
+func TestJitteredDuration(t *testing.T) {
+	period := 10 * time.Minute
+	for _, jitter := range []float64{0, 0.1, 0.99} {
+		low := period - time.Duration(jitter*float64(period))
+		high := period + time.Duration(jitter*float64(period))
+		for i := 0; i < 1000; i++ {
+			if d := jitteredDuration(period, jitter); d < low || d > high {
+				t.Fatalf("jitteredDuration(%s, %v) = %s, want within [%s, %s]", period, jitter, d, low, high)
+			}
+		}
+	}
+}
+
+func TestParseRefreshJitter(t *testing.T) {
+	if jitter, err := parseRefreshJitter("0.25"); err != nil || jitter != 0.25 {
+		t.Errorf("parseRefreshJitter(0.25) = %v, %v", jitter, err)
+	}
+	for _, s := range []string{"-0.1", "1", "1.5", "abc"} {
+		if _, err := parseRefreshJitter(s); err == nil {
+			t.Errorf("parseRefreshJitter(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	var blockOnce sync.Once
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := inFlight.Add(1); n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+		defer inFlight.Add(-1)
+		switch {
+		case r.URL.Path == "/rest/api/3/status":
+			fmt.Fprint(w, `[]`)
+		case r.URL.Query().Get("startAt") == "0":
+			blockOnce.Do(func() {
+				close(entered)
+				<-release
+			})
+			fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+		default:
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.cache = &issueCache{}
+	cfg.instances = []config{cfg}
+	cfg.refreshEndpointEnabled = true
+	registry := registerTestMetrics(t, cfg)
+	handler := metricsHandler(cfg)
+
+	post := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/refresh", nil))
+		return recorder
+	}
+	responses := make(chan *httptest.ResponseRecorder, 2)
+	go func() { responses <- post() }()
+	<-entered
+	go func() { responses <- post() }()
+	// The second refresh must wait for the first one instead of calling Jira
+	time.Sleep(50 * time.Millisecond)
+	if inFlight.Load() != 1 {
+		t.Errorf("%d requests to Jira in flight, want the refreshes serialized", inFlight.Load())
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		recorder := <-responses
+		if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"fetched":1}` {
+			t.Errorf("response = %d %q, want 200 with 1 fetched issue", recorder.Code, recorder.Body.String())
+		}
+	}
+	if maxInFlight.Load() != 1 {
+		t.Errorf("up to %d requests to Jira in flight, want 1", maxInFlight.Load())
+	}
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_issue_count = %v, want 1 after the manual refresh", counts)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/refresh", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /refresh status = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRefreshHandlerDisabled(t *testing.T) {
+	cfg := testConfig(t)
+	recorder := httptest.NewRecorder()
+	metricsHandler(cfg).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/refresh", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestForbiddenProjectSkipped(t *testing.T) {
+	var jqls []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jql := r.URL.Query().Get("jql")
+		jqls = append(jqls, jql)
+		switch {
+		case strings.Contains(jql, "SECRET"):
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errorMessages": ["The value 'SECRET' does not exist for the field 'project'."], "errors": {}}`)
+		case r.URL.Query().Get("startAt") == "0":
+			fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+		default:
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	var err error
+	if cfg.projects, err = parseProjects("SECRET,PROJ", cfg.analyzePeriod); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Key != "PROJ-1" {
+		t.Errorf("issues = %v, want PROJ-1 of the accessible project", issues)
+	}
+	if want := "updated >= -90d AND project in (SECRET)"; jqls[0] != want {
+		t.Errorf("JQL = %q, want a query per project %q", jqls[0], want)
+	}
+	errs := gatherMetrics(t, registry, "jira_exporter_project_errors_total")
+	if len(errs) != 1 || findMetric(errs, map[string]string{"project": "SECRET"}).GetCounter().GetValue() != 1 {
+		t.Errorf("jira_exporter_project_errors_total = %v, want 1 for SECRET", errs)
+	}
+
+	// Without accessible projects, the refresh fails and keeps the previous metrics
+	if cfg.projects, err = parseProjects("SECRET", cfg.analyzePeriod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchJiraData(context.Background(), cfg); err == nil {
+		t.Error("fetchJiraData() succeeded without accessible projects, want an error")
+	}
+}
+
+func TestBadRequestNotSkipped(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errorMessages": ["Error in the JQL Query: Expecting a field name."], "errors": {}}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err == nil {
+		t.Error("fetchJiraData() succeeded, want the JQL error")
+	}
+	if errs := gatherMetrics(t, registry, "jira_exporter_project_errors_total"); len(errs) != 0 {
+		t.Errorf("jira_exporter_project_errors_total = %v, want no project errors", errs)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var times []time.Time
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if startAt := r.URL.Query().Get("startAt"); startAt != "4" {
+			fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON("PROJ-"+startAt))
+			return
+		}
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.limiter = rate.NewLimiter(20, 1)
+	registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 5 {
+		t.Fatalf("%d requests, want 5 pages", len(times))
+	}
+	// 20 RPS allow a request every 50ms, with some slack for the timer
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("request %d came %s after the previous one, want at least 50ms", i, gap)
+		}
+	}
+}
+
+func TestResolutionTime(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	for i, data := range []struct{ priority, resolved string }{
+		{"High", `"2024-01-01T12:00:00.000+0000"`},
+		{"High", `"2024-01-01T14:00:00.000+0000"`},
+		{"Low", `"2024-01-03T10:00:00.000+0000"`},
+		{"Low", `null`},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "resolutiondate": %s, "priority": {"name": %q},
+			"status": {"name": "Done"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`, i, data.resolved, data.priority)))
+	}
+	times := gatherMetrics(t, registry, "jira_issue_resolution_time_seconds")
+	if len(times) != 2 {
+		t.Errorf("jira_issue_resolution_time_seconds has %d series, want 2", len(times))
+	}
+	for priority, want := range map[string]struct {
+		count uint64
+		sum   float64
+	}{
+		"High": {2, (2*time.Hour + 4*time.Hour).Seconds()},
+		"Low":  {1, (2 * day).Seconds()},
+	} {
+		histogram := findMetric(times, map[string]string{"project": "PROJ", "priority": priority}).GetHistogram()
+		if histogram.GetSampleCount() != want.count || histogram.GetSampleSum() != want.sum {
+			t.Errorf("%s: count = %d, sum = %v, want %d, %v", priority, histogram.GetSampleCount(), histogram.GetSampleSum(), want.count, want.sum)
+		}
+	}
+}
+
+func TestWindowField(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	for _, field := range []string{"updated", "created", "resolved"} {
+		var err error
+		if cfg.windowField, err = parseWindowField(field); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buildJQL(cfg, cfg.projects[0], now), field+" >= -90d AND project in (PROJ)"; got != want {
+			t.Errorf("buildJQL() = %q, want %q", got, want)
+		}
+	}
+	for _, field := range []string{"", "Updated", "resolutiondate"} {
+		if _, err := parseWindowField(field); err == nil {
+			t.Errorf("parseWindowField(%q) succeeded, want an error", field)
+		}
+	}
+}
+
+func TestOutOfOrderChangelog(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	// The histories are shuffled, and the first status change is dated before the creation
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-02T10:00:00.000+0000",
+		"status": {"name": "Done"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress"}]},
+			{"created": "2024-01-05T10:00:00.000+0000", "items": [{"field": "status", "fromString": "Review"}]},
+			{"created": "2024-01-01T10:00:00.000+0000", "items": [{"field": "status", "fromString": "Open"}]},
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress"}]}]}}`)
+	durations, negative := statusDurations(cfg, issue)
+	want := map[string]time.Duration{"Open": 0, "In Progress": 48 * time.Hour, "Review": 24 * time.Hour}
+	if !maps.Equal(durations, want) || negative != 1 {
+		t.Errorf("statusDurations() = %v, %d, want %v and 1 negative duration", durations, negative, want)
+	}
+
+	issueCollector.processIssue(cfg, issue)
+	negatives := gatherMetrics(t, registry, "jira_issue_negative_duration_total")
+	if len(negatives) != 1 || negatives[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_issue_negative_duration_total = %v, want 1", negatives)
+	}
+	histogram := findMetric(gatherMetrics(t, registry, "jira_issue_time_in_status"), map[string]string{"project": "PROJ"}).GetHistogram()
+	if histogram.GetSampleCount() != 3 || histogram.GetSampleSum() != (72*time.Hour).Seconds() {
+		t.Errorf("jira_issue_time_in_status count = %d, sum = %v, want 3 non-negative durations of 72h in total",
+			histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestFieldChanges(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.trackFields = parseList("status, Priority,Story Points,resolution")
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"status": {"name": "Done"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [
+				{"field": "status", "fromString": "In Progress"},
+				{"field": "Story Points", "fromString": "5", "toString": "8"}]},
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "priority", "fromString": "Low", "toString": "High"}]},
+			{"created": "2024-01-02T10:00:00.000+0000", "items": [
+				{"field": "status", "fromString": "Open"},
+				{"field": "Story Points", "fromString": "3", "toString": "5"},
+				{"field": "assignee"}]}]}}`))
+
+	changes := gatherMetrics(t, registry, "jira_issue_field_changes_total")
+	want := map[string]float64{"status": 2, "Priority": 1, "Story Points": 2}
+	if len(changes) != len(want) {
+		t.Errorf("jira_issue_field_changes_total has %d series, want %d", len(changes), len(want))
+	}
+	for field, count := range want {
+		if metric := findMetric(changes, map[string]string{"project": "PROJ", "field": field}); metric.GetGauge().GetValue() != count {
+			t.Errorf("jira_issue_field_changes_total{field=%q} = %v, want %v", field, metric.GetGauge().GetValue(), count)
+		}
+	}
+}
+
+func TestAssigneeLabelSource(t *testing.T) {
+	assigned := parseIssue(t, `{"key": "PROJ-1", "fields": {"assignee": {"accountId": "5b10a2844c20165700ede21g",
+		"emailAddress": "alice@example.com", "displayName": "Alice"}}}`)
+	hidden := parseIssue(t, `{"key": "PROJ-2", "fields": {"assignee": {"accountId": "5b10ac8d82e05b22cc7d4ef5",
+		"displayName": "Bob"}}}`)
+	accountOnly := parseIssue(t, `{"key": "PROJ-3", "fields": {"assignee": {"accountId": "557058:f58131cb"}}}`)
+	nameOnly := parseIssue(t, `{"key": "PROJ-4", "fields": {"assignee": {"displayName": "Carol"}}}`)
+	unassigned := parseIssue(t, `{"key": "PROJ-5", "fields": {"assignee": null}}`)
+	for _, tt := range []struct {
+		source string
+		want   []string
+	}{
+		{"email", []string{"alice@example.com", "5b10ac8d82e05b22cc7d4ef5", "557058:f58131cb", "Carol", "unassigned"}},
+		{"accountId", []string{"5b10a2844c20165700ede21g", "5b10ac8d82e05b22cc7d4ef5", "557058:f58131cb", "Carol", "unassigned"}},
+		{"displayName", []string{"Alice", "Bob", "557058:f58131cb", "Carol", "unassigned"}},
+	} {
+		t.Run(tt.source, func(t *testing.T) {
+			cfg := config{assigneeLabelSource: tt.source}
+			got := make([]string, 0)
+			for _, issue := range []JiraIssue{assigned, hidden, accountOnly, nameOnly, unassigned} {
+				got = append(got, assigneeLabel(cfg, issue))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("assigneeLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if !hasAssignee(hidden) || hasAssignee(unassigned) {
+		t.Error("hasAssignee() must detect the assignee by the account ID regardless of the email")
+	}
+}
+
+func TestAssigneeLabelInMetrics(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.assigneeLabelSource = "displayName"
+	registry := registerTestMetrics(t, cfg)
+	issue := parseIssue(t, testIssueWithTransitionJSON("PROJ-1"))
+	issue.Fields.Assignee.DisplayName = "Alice"
+	issueCollector.processIssue(cfg, issue)
+
+	for _, name := range []string{"jira_issue_count", "jira_issue_time_in_status"} {
+		if metric := findMetric(gatherMetrics(t, registry, name), map[string]string{"assignee": "Alice"}); metric == nil {
+			t.Errorf("%s has no series with assignee=\"Alice\"", name)
+		}
+	}
+}
+
+func TestStatusErrorBody(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errorMessages": ["Error in the JQL Query: The character '%' is a reserved JQL character."],
+			"errors": {}, "token": "api-token-secret", "padding": "`+strings.Repeat("x", 4<<10)+`"}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.jiraAPIToken = "api-token-secret"
+	registerTestMetrics(t, cfg)
+
+	_, err := fetchJiraData(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("fetchJiraData() succeeded, want the JQL error")
+	}
+	message := err.Error()
+	if !strings.Contains(message, "400 Bad Request") || !strings.Contains(message, "The character '%' is a reserved JQL character.") {
+		t.Errorf("error = %q, want the status and the Jira error message", message)
+	}
+	if strings.Contains(message, "api-token-secret") {
+		t.Errorf("error = %q, want the token redacted", message)
+	}
+	if len(message) > maxErrorSnippetSize+100 {
+		t.Errorf("error has %d bytes, want the body truncated to %d", len(message), maxErrorSnippetSize)
+	}
+}
+
+func TestJiraAPIError(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/changelog") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"errorMessages": ["Rate limit exceeded."]}`)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registerTestMetrics(t, cfg)
+
+	_, _, err := fetchStartingFrom(context.Background(), cfg, "project = PROJ", 0, 0)
+	var apiErr *JiraAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("fetchStartingFrom() error = %v, want a JiraAPIError", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Endpoint != "/rest/api/3/search" || apiErr.Body != "" {
+		t.Errorf("JiraAPIError = %+v, want 401 from /rest/api/3/search without a body", apiErr)
+	}
+	if cause := classifyFetchError(err); cause != "auth" {
+		t.Errorf("classifyFetchError() = %q, want auth", cause)
+	}
+
+	err = getJSON(context.Background(), cfg, jira.URL+"/rest/api/3/issue/PROJ-1/changelog", &struct{}{})
+	if !errors.As(fmt.Errorf("PROJ-1: %w", err), &apiErr) {
+		t.Fatalf("getJSON() error = %v, want a JiraAPIError", err)
+	}
+	want := JiraAPIError{
+		StatusCode: http.StatusTooManyRequests,
+		Status:     "429 Too Many Requests",
+		Endpoint:   "/rest/api/3/issue/{key}/changelog",
+		Body:       `{"errorMessages": ["Rate limit exceeded."]}`,
+	}
+	if *apiErr != want {
+		t.Errorf("JiraAPIError = %+v, want %+v", *apiErr, want)
+	}
+	if cause := classifyFetchError(err); cause != "ratelimit" {
+		t.Errorf("classifyFetchError() = %q, want ratelimit", cause)
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	var encodings []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		// The first page is compressed, the last one is plain as from a server ignoring Accept-Encoding
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		fmt.Fprintf(writer, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+		if err := writer.Close(); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Key != "PROJ-1" || issues[0].Fields.Status.Name != "Open" {
+		t.Errorf("issues = %v, want PROJ-1 decoded from the compressed page", issues)
+	}
+	for _, encoding := range encodings {
+		if encoding != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want gzip", encoding)
+		}
+	}
+}
+
+func TestInitialScrapeTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.dataRefreshPeriod = time.Hour
+	cfg.initialScrapeTimeout = 50 * time.Millisecond
+	registerTestMetrics(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	var durations []time.Duration
+	var deadlines []bool
+	refreshLoop(ctx, cfg, func(refreshCtx context.Context) error {
+		_, hasDeadline := refreshCtx.Deadline()
+		deadlines = append(deadlines, hasDeadline)
+		if len(deadlines) == 1 {
+			// The cold start takes longer than the timeout
+			<-refreshCtx.Done()
+			durations = append(durations, time.Since(start))
+			return refreshCtx.Err()
+		}
+		durations = append(durations, time.Since(start))
+		cancel()
+		return nil
+	})
+	if !slices.Equal(deadlines, []bool{true, false}) {
+		t.Fatalf("deadlines = %v, want only the first refresh bounded", deadlines)
+	}
+	if durations[0] < cfg.initialScrapeTimeout || durations[0] > 10*cfg.initialScrapeTimeout {
+		t.Errorf("the first refresh took %s, want it bounded by %s", durations[0], cfg.initialScrapeTimeout)
+	}
+	if durations[1] > time.Minute {
+		t.Errorf("the retry ran after %s, want it right after the timed out refresh", durations[1])
+	}
+}
+
+func TestAPIRequestDuration(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/changelog"):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Query().Get("startAt") == "0":
+			fmt.Fprint(w, `{"issues": [{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000"},
+				"changelog": {"total": 2, "histories": []}}]}`)
+		default:
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	// A Jira URL with a context path
+	cfg.jiraURL = jira.URL + "/jira"
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err == nil {
+		t.Fatal("fetchJiraData() succeeded, want the changelog error")
+	}
+	durations := gatherMetrics(t, registry, "jira_api_request_duration_seconds")
+	for _, tt := range []struct {
+		endpoint, status string
+		count            uint64
+	}{
+		{"/rest/api/3/search", "2xx", 2},
+		{"/rest/api/3/issue/{key}/changelog", "5xx", 1},
+	} {
+		metric := findMetric(durations, map[string]string{"endpoint": tt.endpoint, "status": tt.status})
+		if metric.GetHistogram().GetSampleCount() != tt.count {
+			t.Errorf("jira_api_request_duration_seconds{endpoint=%q,status=%q} has %d observations, want %d",
+				tt.endpoint, tt.status, metric.GetHistogram().GetSampleCount(), tt.count)
+		}
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/rest/api/3/search":                 "/rest/api/3/search",
+		"/jira/rest/api/3/search/jql":        "/rest/api/3/search/jql",
+		"/rest/api/3/issue/PROJ-1":           "/rest/api/3/issue/{key}",
+		"/rest/api/3/issue/PROJ-1/changelog": "/rest/api/3/issue/{key}/changelog",
+	} {
+		if got := apiEndpoint(path); got != want {
+			t.Errorf("apiEndpoint(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSLABreaches(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.slaThresholds, err = parseSLAThresholds("In Review=2d, In Progress = 36h"); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	issue := func(key string, inReview, inProgress time.Duration) JiraIssue {
+		created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+		review := created.Add(inProgress)
+		done := review.Add(inReview)
+		return parseIssue(t, fmt.Sprintf(`{"key": %q, "fields": {"created": %q, "status": {"name": "Done"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+			"changelog": {"histories": [
+				{"created": %q, "items": [{"field": "status", "fromString": "In Review", "toString": "Done"}]},
+				{"created": %q, "items": [{"field": "status", "fromString": "In Progress", "toString": "In Review"}]}]}}`,
+			key, created.Format(jiraTimeFormat), done.Format(jiraTimeFormat), review.Format(jiraTimeFormat)))
+	}
+	issueCollector.processIssue(cfg, issue("PROJ-1", 2*day+time.Second, 36*time.Hour-time.Second))
+	issueCollector.processIssue(cfg, issue("PROJ-2", 2*day-time.Second, 36*time.Hour+time.Second))
+	issueCollector.processIssue(cfg, issue("PROJ-3", 2*day, 36*time.Hour))
+	issueCollector.processIssue(cfg, issue("PROJ-4", 3*day, time.Hour))
+
+	breaches := gatherMetrics(t, registry, "jira_issue_sla_breach_total")
+	for status, want := range map[string]float64{"In Review": 2, "In Progress": 1} {
+		if metric := findMetric(breaches, map[string]string{"project": "PROJ", "status": status}); metric == nil || metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_issue_sla_breach_total{status=%q} = %v, want %v", status, metric.GetGauge(), want)
+		}
+	}
+}
+
+func TestParseSLAThresholds(t *testing.T) {
+	thresholds, err := parseSLAThresholds("Triage=4h,In Review=2d,Blocked=1w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{"Triage": 4 * time.Hour, "In Review": 2 * day, "Blocked": 7 * day}
+	if !maps.Equal(thresholds, want) {
+		t.Errorf("parseSLAThresholds() = %v, want %v", thresholds, want)
+	}
+	for _, spec := range []string{"In Review", "=2d", "In Review=soon", "In Review=0"} {
+		if _, err := parseSLAThresholds(spec); err == nil {
+			t.Errorf("parseSLAThresholds(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestOpenIssueAgeBuckets(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	for i, issue := range []struct {
+		status string
+		age    time.Duration
+	}{
+		{"Open", time.Hour},
+		{"Open", 23 * time.Hour},
+		{"Open", day + time.Hour},
+		{"Open", 3*day - time.Hour},
+		{"Open", 3*day + time.Hour},
+		{"In Progress", 8 * day},
+		{"In Progress", 100 * day},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {"created": %q,
+			"status": {"name": %q}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`,
+			i, now.Add(-issue.age).Format(jiraTimeFormat), issue.status)))
+	}
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-99", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"status": {"name": "Done", "statusCategory": {"key": "done"}}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`))
+
+	counts := gatherMetrics(t, registry, "jira_open_issue_age_bucket_count")
+	want := map[[2]string]float64{
+		{"Open", "<1d"}:        2,
+		{"Open", "1d-3d"}:      2,
+		{"Open", "3d-7d"}:      1,
+		{"In Progress", ">7d"}: 2,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("jira_open_issue_age_bucket_count has %d series, want %d", len(counts), len(want))
+	}
+	for key, value := range want {
+		if metric := findMetric(counts, map[string]string{"status": key[0], "bucket": key[1]}); metric == nil || metric.GetGauge().GetValue() != value {
+			t.Errorf("jira_open_issue_age_bucket_count{status=%q,bucket=%q} = %v, want %v", key[0], key[1], metric.GetGauge(), value)
+		}
+	}
+}
+
+func TestParseOpenAgeBuckets(t *testing.T) {
+	bounds, err := parseOpenAgeBuckets("12h, 1d,2w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for age, want := range map[time.Duration]string{
+		time.Hour:      "<12h",
+		12 * time.Hour: "12h-1d",
+		day:            "1d-14d",
+		20 * day:       ">14d",
+	} {
+		if got := ageBucket(age, bounds); got != want {
+			t.Errorf("ageBucket(%s) = %q, want %q", age, got, want)
+		}
+	}
+	for _, spec := range []string{"", "1d,1d", "3d,1d", "0", "soon"} {
+		if _, err := parseOpenAgeBuckets(spec); err == nil {
+			t.Errorf("parseOpenAgeBuckets(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestIssueTypeBuckets(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.issueTypeBuckets, err = parseIssueTypeBuckets("Bug=1h,1d; Epic = 1w,4w"); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	for i, issueType := range []string{"Bug", "Epic", "Task"} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Done"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": %q}},
+			"changelog": {"histories": [
+				{"created": "2024-01-01T12:00:00.000+0000", "items": [{"field": "status", "fromString": "Open", "toString": "Done"}]}]}}`,
+			i, issueType)))
+	}
+
+	histograms := gatherMetrics(t, registry, "jira_issue_time_in_status")
+	if len(histograms) != 3 {
+		t.Fatalf("jira_issue_time_in_status has %d series, want 3", len(histograms))
+	}
+	for issueType, want := range map[string][]float64{
+		"Bug":  {3600, 86400},
+		"Epic": {7 * 86400, 28 * 86400},
+		"Task": prometheus.ExponentialBuckets(1, 10, 8),
+	} {
+		metric := findMetric(histograms, map[string]string{"issueType": issueType, "project": "PROJ"})
+		if metric == nil {
+			t.Errorf("no jira_issue_time_in_status{issueType=%q}", issueType)
+			continue
+		}
+		bounds := make([]float64, 0)
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			bounds = append(bounds, bucket.GetUpperBound())
+		}
+		if !slices.Equal(bounds, want) {
+			t.Errorf("jira_issue_time_in_status{issueType=%q} buckets = %v, want %v", issueType, bounds, want)
+		}
+		if sum := metric.GetHistogram().GetSampleSum(); sum != 7200 {
+			t.Errorf("jira_issue_time_in_status{issueType=%q} sum = %v, want 7200", issueType, sum)
+		}
+	}
+
+	for _, spec := range []string{"Bug", "=1h", "Bug=", "Bug=1d,1h", "Bug=soon"} {
+		if _, err := parseIssueTypeBuckets(spec); err == nil {
+			t.Errorf("parseIssueTypeBuckets(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestIgnoreStatuses(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ignoreStatuses = parseList("Backlog, Open")
+	registry := registerTestMetrics(t, cfg)
+	// Open 1h -> Backlog 10d -> In Progress 2h -> Backlog 5d -> In Progress 3h -> Done
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Done"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-16T16:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress", "toString": "Done"}]},
+			{"created": "2024-01-16T13:00:00.000+0000", "items": [{"field": "status", "fromString": "Backlog", "toString": "In Progress"}]},
+			{"created": "2024-01-11T13:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress", "toString": "Backlog"}]},
+			{"created": "2024-01-11T11:00:00.000+0000", "items": [{"field": "status", "fromString": "Backlog", "toString": "In Progress"}]},
+			{"created": "2024-01-01T11:00:00.000+0000", "items": [{"field": "status", "fromString": "Open", "toString": "Backlog"}]}]}}`))
+
+	histograms := gatherMetrics(t, registry, "jira_issue_time_in_status")
+	if len(histograms) != 1 {
+		t.Fatalf("jira_issue_time_in_status has %d series, want 1", len(histograms))
+	}
+	histogram := histograms[0].GetHistogram()
+	if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != (5*time.Hour).Seconds() {
+		t.Errorf("jira_issue_time_in_status has %d observations summing to %vs, want only In Progress for 5h",
+			histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestMaxIssues(t *testing.T) {
+	var jqls []string
+	var runaway atomic.Bool
+	runaway.Store(true)
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !runaway.Load() {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		// Every page is full, as with a runaway query
+		jqls = append(jqls, r.URL.Query().Get("jql"))
+		startAt, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+		fmt.Fprintf(w, `{"issues": [%s, %s]}`, testIssueJSON(fmt.Sprintf("PROJ-%d", startAt)), testIssueJSON(fmt.Sprintf("PROJ-%d", startAt+1)))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.maxIssues = 5
+	var err error
+	if cfg.projects, err = parseProjects("PROJ,OTHER", cfg.analyzePeriod); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 5 {
+		t.Errorf("fetched %d issues, want the limit of 5", len(issues))
+	}
+	if len(jqls) != 3 || slices.ContainsFunc(jqls, func(jql string) bool { return strings.Contains(jql, "OTHER") }) {
+		t.Errorf("JQLs = %q, want 3 pages of PROJ only", jqls)
+	}
+	if hit := gatherMetrics(t, registry, "jira_exporter_issue_limit_hit"); len(hit) != 1 || hit[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_exporter_issue_limit_hit = %v, want 1", hit)
+	}
+
+	cfg.maxIssues = 0
+	runaway.Store(false)
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if hit := gatherMetrics(t, registry, "jira_exporter_issue_limit_hit"); len(hit) != 1 || hit[0].GetGauge().GetValue() != 0 {
+		t.Errorf("jira_exporter_issue_limit_hit = %v without the limit, want 0", hit)
+	}
+}
+
+func TestMaxIssuesExactly(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		total           bool
+		tokenPagination bool
+		issues          int
+		want            float64
+	}{
+		{"with the total", true, false, 4, 0},
+		{"without the total", false, false, 4, 0},
+		{"with the page tokens", false, true, 4, 0},
+		{"one over the limit with the total", true, false, 5, 1},
+		{"one over the limit without the total", false, false, 5, 1},
+		{"one over the limit with the page tokens", false, true, 5, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The query has the issues in pages of 2
+			jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+				if tt.tokenPagination {
+					start, _ = strconv.Atoi(r.URL.Query().Get("nextPageToken"))
+				}
+				keys := make([]string, 0)
+				for i := start; i < min(start+2, tt.issues); i++ {
+					keys = append(keys, testIssueJSON(fmt.Sprintf("PROJ-%d", i)))
+				}
+				response := map[string]any{"issues": json.RawMessage("[" + strings.Join(keys, ",") + "]")}
+				if tt.total {
+					response["total"] = tt.issues
+				}
+				if start+2 < tt.issues {
+					response["nextPageToken"] = strconv.Itoa(start + 2)
+				}
+				_ = json.NewEncoder(w).Encode(response)
+			}))
+			defer jira.Close()
+			cfg := testConfig(t)
+			cfg.jiraURL = jira.URL
+			cfg.client = jira.Client()
+			cfg.tokenPagination = tt.tokenPagination
+			cfg.maxIssues = 4
+			registry := registerTestMetrics(t, cfg)
+
+			issues, err := fetchJiraData(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != 4 {
+				t.Errorf("fetched %d issues, want 4", len(issues))
+			}
+			if hit := gatherMetrics(t, registry, "jira_exporter_issue_limit_hit"); len(hit) != 1 || hit[0].GetGauge().GetValue() != tt.want {
+				t.Errorf("jira_exporter_issue_limit_hit = %v, want %v", hit, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveUpdatedCount(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.windowField = "created"
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	for i, issue := range []struct {
+		project, category string
+		updated           time.Time
+	}{
+		{"PROJ", "indeterminate", now.Add(-time.Hour)},
+		{"PROJ", "new", now.Add(-89 * day)},
+		{"PROJ", "done", now.Add(-time.Hour)},
+		{"PROJ", "indeterminate", now.Add(-91 * day)},
+		{"OPS", "done", now.Add(-time.Hour)},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "%s-%d", "fields": {
+			"created": "2020-01-01T10:00:00.000+0000", "updated": %q,
+			"status": {"name": "Status", "statusCategory": {"key": %q}},
+			"project": {"key": %q}, "issuetype": {"name": "Task"}}}`,
+			issue.project, i, issue.updated.Format(jiraTimeFormat), issue.category, issue.project)))
+	}
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-99", "fields": {"created": "2020-01-01T10:00:00.000+0000",
+		"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`))
+
+	counts := gatherMetrics(t, registry, "jira_issue_active_updated_count")
+	if len(counts) != 1 {
+		t.Errorf("jira_issue_active_updated_count has %d series, want only PROJ", len(counts))
+	}
+	if metric := findMetric(counts, map[string]string{"project": "PROJ"}); metric == nil || metric.GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issue_active_updated_count{project=\"PROJ\"} = %v, want 2", metric.GetGauge())
+	}
+}
+
+func TestCommentCount(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueJSON("PROJ-1")))
+	if counts := gatherMetrics(t, registry, "jira_issue_comment_count"); len(counts) != 0 {
+		t.Errorf("jira_issue_comment_count has %d series without the comment field requested, want none", len(counts))
+	}
+
+	var err error
+	if cfg.fields, err = parseFields("created,status,project,issuetype,comment", cfg); err != nil {
+		t.Fatal(err)
+	}
+	registry = registerTestMetrics(t, cfg)
+	for i, issue := range []struct{ issueType, comment string }{
+		{"Bug", `, "comment": {"comments": [{"id": "1"}, {"id": "2"}, {"id": "3"}], "total": 3}`},
+		{"Bug", `, "comment": {"comments": [], "total": 0}`},
+		{"Bug", ``},
+		{"Task", `, "comment": {"comments": [{"id": "1"}], "maxResults": 1, "total": 12}`},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": %q}%s}}`, i, issue.issueType, issue.comment)))
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_comment_count")
+	for issueType, want := range map[string]struct {
+		count uint64
+		sum   float64
+	}{"Bug": {3, 3}, "Task": {1, 12}} {
+		metric := findMetric(counts, map[string]string{"project": "PROJ", "issueType": issueType})
+		if metric.GetHistogram().GetSampleCount() != want.count || metric.GetHistogram().GetSampleSum() != want.sum {
+			t.Errorf("jira_issue_comment_count{issueType=%q} has %d observations summing to %v, want %d summing to %v", issueType,
+				metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum(), want.count, want.sum)
+		}
+	}
+}
+
+func TestPullScrapeMode(t *testing.T) {
+	var fetches atomic.Int32
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rest/api/3/status":
+			fmt.Fprint(w, `[]`)
+		case r.URL.Query().Get("startAt") == "0":
+			fetches.Add(1)
+			fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+		default:
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.cache = &issueCache{}
+	cfg.instances = []config{cfg}
+	registerTestMetrics(t, cfg)
+	scrape := func(handler http.Handler) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	cfg.scrapeMode = "poll"
+	if scrape(metricsHandler(cfg)); fetches.Load() != 0 {
+		t.Errorf("a scrape in the poll mode fetched %d times, want none", fetches.Load())
+	}
+
+	cfg.scrapeMode = "pull"
+	cfg.dataRefreshPeriod = time.Hour
+	handler := metricsHandler(cfg)
+	if body := scrape(handler); !strings.Contains(body, `jira_issue_count{`) {
+		t.Errorf("the first scrape in the pull mode has no jira_issue_count:\n%s", body)
+	}
+	scrape(handler)
+	if fetches.Load() != 1 {
+		t.Errorf("2 scrapes within DATA_REFRESH_PERIOD fetched %d times, want once", fetches.Load())
+	}
+
+	cfg.dataRefreshPeriod = 0
+	handler = metricsHandler(cfg)
+	scrape(handler)
+	scrape(handler)
+	if fetches.Load() != 3 {
+		t.Errorf("2 scrapes after DATA_REFRESH_PERIOD fetched %d times, want twice", fetches.Load()-1)
+	}
+}
+
+func TestParseJiraTime(t *testing.T) {
+	want := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"2024-01-02T10:00:00.000+0000":    want,
+		"2024-01-02T13:00:00.000+0300":    want,
+		"2024-01-02T10:00:00+0000":        want,
+		"2024-01-02T10:00:00.123456+0000": want.Add(123456 * time.Microsecond),
+		"2024-01-02T10:00:00.000Z":        want,
+		"2024-01-02T10:00:00Z":            want,
+		"2024-01-02T05:00:00.000-05:00":   want,
+		"2024-01-02T10:00:00.5+00:00":     want.Add(500 * time.Millisecond),
+		"2024-01-02T10:00:00.000000000Z":  want,
+		"2024-01-02T15:30:00.000+05:30":   want,
+	} {
+		got, err := parseJiraTime(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseJiraTime(%q) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "2024-01-02", "02/Jan/24 10:00 AM", "2024-01-02 10:00:00"} {
+		if _, err := parseJiraTime(s); err == nil {
+			t.Errorf("parseJiraTime(%q) succeeded, want an error", s)
+		}
+	}
+
+	previous := jiraTimeLayouts
+	jiraTimeLayouts = append(slices.Clone(jiraTimeLayouts), "02/Jan/06 3:04 PM")
+	t.Cleanup(func() { jiraTimeLayouts = previous })
+	if got, err := parseJiraTime("02/Jan/24 10:00 AM"); err != nil || !got.Equal(want) {
+		t.Errorf("parseJiraTime() with the custom layout = %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestCycleTimeStart(t *testing.T) {
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"resolutiondate": "2024-01-10T10:00:00.000+0000", "status": {"name": "Done"}, "project": {"key": "PROJ"},
+		"issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-09T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Review", "toString": "Done"}]},
+			{"created": "2024-01-06T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress", "toString": "In Review"}]},
+			{"created": "2024-01-05T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Review", "toString": "In Progress"}]},
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress", "toString": "In Review"}]},
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "assignee", "toString": "In Progress"}]},
+			{"created": "2024-01-02T10:00:00.000+0000", "items": [{"field": "status", "fromString": "To Do", "toString": "In Progress"}]}
+		]}}`)
+	for _, test := range []struct {
+		statuses []string
+		want     string
+		ok       bool
+	}{
+		{[]string{"In Progress"}, "2024-01-02T10:00:00.000+0000", true},
+		{[]string{"in review"}, "2024-01-04T10:00:00.000+0000", true},
+		{[]string{"In Review", "In Progress"}, "2024-01-02T10:00:00.000+0000", true},
+		{[]string{"Blocked"}, "", false},
+		{nil, "", false},
+	} {
+		start, ok := cycleTimeStart(issue, test.statuses)
+		if ok != test.ok {
+			t.Errorf("cycleTimeStart(%v) found = %v, want %v", test.statuses, ok, test.ok)
+			continue
+		}
+		if ok && !start.Equal(mustTimeParse(test.want)) {
+			t.Errorf("cycleTimeStart(%v) = %s, want %s", test.statuses, start, test.want)
+		}
+	}
+
+	cfg := testConfig(t)
+	cfg.cycleTimeStartStatuses = []string{"In Review"}
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, issue)
+	metric := findMetric(gatherMetrics(t, registry, "jira_issue_cycle_time_seconds"), map[string]string{"project": "PROJ", "issueType": "Task"})
+	if metric == nil {
+		t.Fatal("jira_issue_cycle_time_seconds is not emitted")
+	}
+	if got, want := metric.GetHistogram().GetSampleSum(), (6 * day).Seconds(); got != want {
+		t.Errorf("jira_issue_cycle_time_seconds sum = %v, want %v", got, want)
+	}
+}
+
+func TestBlockedIssues(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.blockedStatuses = []string{"Blocked", "On Hold"}
+	registry := registerTestMetrics(t, cfg)
+	issue := func(key, status, histories string) JiraIssue {
+		return parseIssue(t, fmt.Sprintf(`{"key": %q, "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"status": {"name": %q}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+			"changelog": {"histories": [%s]}}`, key, status, histories))
+	}
+	change := func(created, from, to string) string {
+		return fmt.Sprintf(`{"created": "2024-01-0%sT10:00:00.000+0000", "items": [{"field": "status", "fromString": %q, "toString": %q}]}`,
+			created, from, to)
+	}
+	for _, issue := range []JiraIssue{
+		// Blocked twice for 2 and 1 days
+		issue("PROJ-1", "Done", strings.Join([]string{change("6", "Blocked", "Done"), change("5", "In Progress", "Blocked"),
+			change("4", "Blocked", "In Progress"), change("2", "Open", "Blocked")}, ",")),
+		// Still blocked, the time in the current status isn't observed
+		issue("PROJ-2", "Blocked", change("2", "Open", "Blocked")),
+		// On hold for 1 day, in another case
+		issue("PROJ-3", "Open", strings.Join([]string{change("3", "ON HOLD", "Open"), change("2", "Open", "ON HOLD")}, ",")),
+		// Never blocked
+		issue("PROJ-4", "Done", change("2", "Open", "Done")),
+	} {
+		issueCollector.processIssue(cfg, issue)
+	}
+
+	blocked := findMetric(gatherMetrics(t, registry, "jira_issue_blocked_total"), map[string]string{"project": "PROJ"})
+	if blocked.GetGauge().GetValue() != 3 {
+		t.Errorf("jira_issue_blocked_total = %v, want 3", blocked.GetGauge().GetValue())
+	}
+	duration := findMetric(gatherMetrics(t, registry, "jira_issue_blocked_duration_seconds"), map[string]string{"project": "PROJ"})
+	if duration == nil {
+		t.Fatal("jira_issue_blocked_duration_seconds is not emitted")
+	}
+	if got := duration.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("jira_issue_blocked_duration_seconds count = %d, want 2 issues that left the blocked statuses", got)
+	}
+	if got, want := duration.GetHistogram().GetSampleSum(), (4 * day).Seconds(); got != want {
+		t.Errorf("jira_issue_blocked_duration_seconds sum = %v, want %v", got, want)
+	}
+}
+
+func TestLogSampleEveryNPages(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAt, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+		if startAt >= 7 {
+			fmt.Fprint(w, `{"issues": []}`)
+			return
+		}
+		fmt.Fprintf(w, `{"issues": [%s]}`, testIssueJSON(fmt.Sprintf("PROJ-%d", startAt)))
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registerTestMetrics(t, cfg)
+
+	for _, test := range []struct {
+		everyN int
+		want   []string
+	}{
+		{0, []string{"0", "1", "2", "3", "4", "5", "6", "7"}},
+		{1, []string{"0", "1", "2", "3", "4", "5", "6", "7"}},
+		{3, []string{"0", "3", "6"}},
+	} {
+		cfg.logSampleEveryNPages = test.everyN
+		stdout := os.Stdout
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = writer
+		_, _, fetchErr := fetchByJQL(context.Background(), cfg, "project = PROJ", 0)
+		os.Stdout = stdout
+		writer.Close()
+		output, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetchErr != nil {
+			t.Fatal(fetchErr)
+		}
+		var logged []string
+		for _, line := range strings.Split(string(output), "\n") {
+			if startAt, ok := strings.CutPrefix(line, "Fetching Jira data starting from "); ok {
+				logged = append(logged, startAt)
+			}
+		}
+		if !slices.Equal(logged, test.want) {
+			t.Errorf("LOG_SAMPLE_EVERY_N_PAGES=%d logged the pages starting from %v, want %v", test.everyN, logged, test.want)
+		}
+		if pages := strings.Count(string(output), "Fetching http"); pages != len(test.want) {
+			t.Errorf("LOG_SAMPLE_EVERY_N_PAGES=%d logged %d URLs, want %d", test.everyN, pages, len(test.want))
+		}
+	}
+}
+
+func TestReporterCount(t *testing.T) {
+	cfg := testConfig(t)
+	if !slices.Contains(cfg.fields, "reporter") {
+		t.Fatalf("reporter is not requested: %v", cfg.fields)
+	}
+	cfg.reporterLabelSource = "displayName"
+	registry := registerTestMetrics(t, cfg)
+	var issues []JiraIssue
+	for i, fixture := range []struct {
+		project  string
+		reporter string
+	}{
+		{"PROJ", `{"accountId": "1", "displayName": "Alice"}`},
+		{"PROJ", `{"accountId": "1", "displayName": "Alice"}`},
+		{"PROJ", `{"accountId": "2", "displayName": "Bob"}`},
+		{"OTHER", `{"accountId": "1", "displayName": "Alice"}`},
+		{"OTHER", `{"accountId": "3"}`},
+		{"OTHER", `null`},
+	} {
+		issues = append(issues, parseIssue(t, fmt.Sprintf(`{"key": "%s-%d", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+			"reporter": %s, "status": {"name": "Open"}, "project": {"key": %q}, "issuetype": {"name": "Task"}}}`,
+			fixture.project, i, fixture.reporter, fixture.project)))
+	}
+	issueCollector.processInstance(cfg, issues)
+
+	counts := gatherMetrics(t, registry, "jira_issue_reporter_count")
+	// The reporter without a display name falls back to the account ID
+	want := map[[2]string]float64{{"PROJ", "Alice"}: 2, {"PROJ", "Bob"}: 1, {"OTHER", "Alice"}: 1, {"OTHER", "3"}: 1, {"OTHER", "unknown"}: 1}
+	if len(counts) != len(want) {
+		t.Errorf("jira_issue_reporter_count has %d series, want %d", len(counts), len(want))
+	}
+	for labels, value := range want {
+		metric := findMetric(counts, map[string]string{"project": labels[0], "reporter": labels[1]})
+		if metric.GetGauge().GetValue() != value {
+			t.Errorf("jira_issue_reporter_count{project=%q,reporter=%q} = %v, want %v", labels[0], labels[1], metric.GetGauge().GetValue(), value)
+		}
+	}
+
+	// The reporters aren't counted unless requested
+	cfg.fields = slices.DeleteFunc(slices.Clone(cfg.fields), func(field string) bool { return field == "reporter" })
+	issueCollector.Reset()
+	issueCollector.processInstance(cfg, issues)
+	if counts := gatherMetrics(t, registry, "jira_issue_reporter_count"); len(counts) != 0 {
+		t.Errorf("jira_issue_reporter_count has %d series without the reporter field, want none", len(counts))
+	}
+}
+
+func TestPaginationMismatch(t *testing.T) {
+	var reportedTotal atomic.Int32
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAt, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+		if startAt >= 3 {
+			fmt.Fprintf(w, `{"issues": [], "total": %d}`, reportedTotal.Load())
+			return
+		}
+		fmt.Fprintf(w, `{"issues": [%s], "total": %d}`, testIssueJSON(fmt.Sprintf("PROJ-%d", startAt)), reportedTotal.Load())
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+
+	reportedTotal.Store(3)
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches := gatherMetrics(t, registry, "jira_exporter_pagination_mismatch_total"); mismatches != nil {
+		t.Errorf("jira_exporter_pagination_mismatch_total = %v with the matching total, want no series", mismatches)
+	}
+
+	reportedTotal.Store(5)
+	issues, err := fetchJiraData(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 3 {
+		t.Errorf("fetched %d issues, want the 3 served ones", len(issues))
+	}
+	mismatches := gatherMetrics(t, registry, "jira_exporter_pagination_mismatch_total")
+	if len(mismatches) != 1 || mismatches[0].GetCounter().GetValue() != 1 {
+		t.Errorf("jira_exporter_pagination_mismatch_total = %v, want 1", mismatches)
+	}
+}
+
+func TestHTTPPathPrefix(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.httpPathPrefix, err = parsePathPrefix("/jira/"); err != nil {
+		t.Fatal(err)
+	}
+	registerTestMetrics(t, cfg)
+	handler := metricsHandler(cfg)
+	for path, want := range map[string]int{
+		"/jira/metrics":  http.StatusOK,
+		"/jira/liveness": http.StatusOK,
+		"/jira/config":   http.StatusOK,
+		"/metrics":       http.StatusNotFound,
+		"/liveness":      http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, recorder.Code, want)
+		}
+	}
+
+	for prefix, want := range map[string]string{"": "", "/": "", "/jira": "/jira", "/a/b//": "/a/b"} {
+		if got, err := parsePathPrefix(prefix); err != nil || got != want {
+			t.Errorf("parsePathPrefix(%q) = %q, %v, want %q", prefix, got, err, want)
+		}
+	}
+	if _, err := parsePathPrefix("jira"); err == nil {
+		t.Error("parsePathPrefix() accepted a prefix without the leading slash")
+	}
+}
+
+func TestTimeSinceLastChange(t *testing.T) {
+	cfg := testConfig(t)
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	for i, histories := range []string{
+		// Out of order, the latest entry is an hour ago
+		fmt.Sprintf(`[{"created": %q, "items": []}, {"created": %q, "items": []}]`,
+			now.Add(-5*day).Format(jiraTimeFormat), now.Add(-time.Hour).Format(jiraTimeFormat)),
+		fmt.Sprintf(`[{"created": %q, "items": [{"field": "status", "fromString": "Open", "toString": "Done"}]}]`,
+			now.Add(-20*day).Format(jiraTimeFormat)),
+		`[]`,
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {"created": "2020-01-01T10:00:00.000+0000",
+			"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+			"changelog": {"histories": %s}}`, i, histories)))
+	}
+	metric := findMetric(gatherMetrics(t, registry, "jira_issue_time_since_last_change_seconds"), map[string]string{"project": "PROJ"})
+	if metric == nil {
+		t.Fatal("jira_issue_time_since_last_change_seconds is not emitted")
+	}
+	histogram := metric.GetHistogram()
+	if histogram.GetSampleCount() != 2 {
+		t.Errorf("jira_issue_time_since_last_change_seconds has %d samples, want the 2 issues with changelog entries", histogram.GetSampleCount())
+	}
+	// The timestamps are formatted with milliseconds, and the test takes a moment to run
+	want := (time.Hour + 20*day).Seconds()
+	if sum := histogram.GetSampleSum(); sum < want-1 || sum > want+5 {
+		t.Errorf("jira_issue_time_since_last_change_seconds sum = %v, want about %v", sum, want)
+	}
+}
+
+func TestExcludeResolvedBefore(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.excludeResolvedBefore = 30 * day
+	registry := registerTestMetrics(t, cfg)
+	now := time.Now()
+	var issues []JiraIssue
+	for i, resolved := range []string{
+		now.Add(-31 * day).Format(jiraTimeFormat),
+		now.Add(-29 * day).Format(jiraTimeFormat),
+		now.Add(-time.Hour).Format(jiraTimeFormat),
+		"",
+	} {
+		issues = append(issues, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {"created": "2020-01-01T10:00:00.000+0000",
+			"resolutiondate": %q, "status": {"name": "Done", "statusCategory": {"key": "done"}}, "project": {"key": "PROJ"},
+			"issuetype": {"name": "Task"}}}`, i, resolved)))
+	}
+	issueCollector.processInstance(cfg, issues)
+
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 3 {
+		t.Errorf("jira_issue_count = %v, want the 3 issues without the old resolved one", counts)
+	}
+	if resolved := gatherMetrics(t, registry, "jira_issues_resolved_total"); len(resolved) != 1 || resolved[0].GetGauge().GetValue() != 2 {
+		t.Errorf("jira_issues_resolved_total = %v, want the 2 recently resolved issues", resolved)
+	}
+	if ratio := gatherMetrics(t, registry, "jira_issue_done_ratio"); len(ratio) != 1 || ratio[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_issue_done_ratio = %v, want 1 over the remaining issues", ratio)
+	}
+
+	cfg.excludeResolvedBefore = 0
+	issueCollector.Reset()
+	issueCollector.processInstance(cfg, issues)
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 4 {
+		t.Errorf("jira_issue_count = %v without the cutoff, want all 4 issues", counts)
+	}
+}
+
+func TestReadinessFailureThreshold(t *testing.T) {
+	previous, previousFailures := lastSuccessfulRefresh.Load(), consecutiveRefreshFailures.Load()
+	t.Cleanup(func() {
+		lastSuccessfulRefresh.Store(previous)
+		consecutiveRefreshFailures.Store(previousFailures)
+	})
+	var failing atomic.Bool
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	cfg.cache = &issueCache{}
+	cfg.instances = []config{cfg}
+	cfg.readinessMaxAge = time.Hour
+	cfg.readinessFailureThreshold = 3
+	registerTestMetrics(t, cfg)
+	handler := readinessHandler(cfg)
+	readiness := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		return recorder.Code
+	}
+
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable} {
+		if _, err := refresh(context.Background(), cfg); err == nil {
+			t.Fatal("refresh() succeeded against the failing Jira")
+		}
+		if got := readiness(); got != want {
+			t.Errorf("readiness after %d failed refreshes = %d, want %d", i+1, got, want)
+		}
+	}
+	// A successful refresh recovers at once
+	failing.Store(false)
+	if _, err := refresh(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := readiness(); got != http.StatusOK {
+		t.Errorf("readiness after the recovery = %d, want %d", got, http.StatusOK)
+	}
+	failing.Store(true)
+	if _, err := refresh(context.Background(), cfg); err == nil {
+		t.Fatal("refresh() succeeded against the failing Jira")
+	}
+	if got := readiness(); got != http.StatusOK {
+		t.Errorf("readiness after a failure following the recovery = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestLinkCount(t *testing.T) {
+	cfg := testConfig(t)
+	if !slices.Contains(cfg.fields, "issuelinks") {
+		t.Fatalf("issuelinks is not requested: %v", cfg.fields)
+	}
+	registry := registerTestMetrics(t, cfg)
+	for i, issue := range []struct{ issueType, links string }{
+		{"Bug", `, "issuelinks": [{"id": "1", "type": {"name": "Blocks"}}, {"id": "2"}, {"id": "3"}]`},
+		{"Bug", `, "issuelinks": []`},
+		{"Bug", ``},
+		{"Story", `, "issuelinks": [{"id": "4"}]`},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": %q}%s}}`, i, issue.issueType, issue.links)))
+	}
+	counts := gatherMetrics(t, registry, "jira_issue_link_count")
+	for issueType, want := range map[string]struct {
+		count uint64
+		sum   float64
+	}{"Bug": {3, 3}, "Story": {1, 1}} {
+		metric := findMetric(counts, map[string]string{"project": "PROJ", "issueType": issueType})
+		if metric.GetHistogram().GetSampleCount() != want.count || metric.GetHistogram().GetSampleSum() != want.sum {
+			t.Errorf("jira_issue_link_count{issueType=%q} has %d observations summing to %v, want %d summing to %v", issueType,
+				metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum(), want.count, want.sum)
+		}
+	}
+	// The issues without links are in the lowest bucket
+	if bucket := findMetric(counts, map[string]string{"issueType": "Bug"}).GetHistogram().GetBucket()[0]; bucket.GetUpperBound() != 1 || bucket.GetCumulativeCount() != 2 {
+		t.Errorf("jira_issue_link_count{issueType=\"Bug\"} bucket le=%v has %d issues, want le=1 with 2", bucket.GetUpperBound(), bucket.GetCumulativeCount())
+	}
+
+	cfg.fields = slices.DeleteFunc(slices.Clone(cfg.fields), func(field string) bool { return field == "issuelinks" })
+	registry = registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueJSON("PROJ-1")))
+	if counts := gatherMetrics(t, registry, "jira_issue_link_count"); len(counts) != 0 {
+		t.Errorf("jira_issue_link_count has %d series without the issuelinks field requested, want none", len(counts))
+	}
+}
+
+func TestStatusProcessingOrder(t *testing.T) {
+	cfg := testConfig(t)
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"status": {"name": "Done"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+		"changelog": {"histories": [
+			{"created": "2024-01-06T10:00:00.000+0000", "items": [{"field": "status", "fromString": "QA", "toString": "Done"}]},
+			{"created": "2024-01-05T10:00:00.000+0000", "items": [{"field": "status", "fromString": "Review", "toString": "QA"}]},
+			{"created": "2024-01-04T10:00:00.000+0000", "items": [{"field": "status", "fromString": "In Progress", "toString": "Review"}]},
+			{"created": "2024-01-03T10:00:00.000+0000", "items": [{"field": "status", "fromString": "Blocked", "toString": "In Progress"}]},
+			{"created": "2024-01-02T10:00:00.000+0000", "items": [{"field": "status", "fromString": "Open", "toString": "Blocked"}]}]}}`)
+	want := []string{"Blocked", "In Progress", "Open", "QA", "Review"}
+	// The map order differs between the iterations, so a few runs would catch the random order
+	for i := 0; i < 20; i++ {
+		durations, _ := statusDurations(cfg, issue)
+		if got := sortedStatuses(durations); !slices.Equal(got, want) {
+			t.Fatalf("sortedStatuses() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFetchBytes(t *testing.T) {
+	var compressed strings.Builder
+	writer := gzip.NewWriter(&compressed)
+	fmt.Fprintf(writer, `{"issues": [%s]}`, testIssueJSON("PROJ-1"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The trailing whitespace isn't decoded, but still received
+	lastPage := `{"issues": []}` + strings.Repeat(" ", 100)
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startAt") != "0" {
+			fmt.Fprint(w, lastPage)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		fmt.Fprint(w, compressed.String())
+	}))
+	defer jira.Close()
+	cfg := testConfig(t)
+	cfg.jiraURL = jira.URL
+	cfg.client = jira.Client()
+	registry := registerTestMetrics(t, cfg)
+
+	if _, err := fetchJiraData(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := float64(compressed.Len() + len(lastPage))
+	if fetched := gatherMetrics(t, registry, "jira_exporter_fetch_bytes_total"); len(fetched) != 1 || fetched[0].GetCounter().GetValue() != want {
+		t.Errorf("jira_exporter_fetch_bytes_total = %v, want %v", fetched, want)
+	}
+}
+
+// fixedClock is a clock stopped at the time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestFixedClock(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.clock = fixedClock(time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC))
+	if got := cfg.now(); !got.Equal(time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("now() = %s, want the fixed time", got)
+	}
+	if got := windowStart(cfg, "PROJ", cfg.now()); !got.Equal(time.Date(2023, 12, 16, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("windowStart() = %s, want 90 days before the fixed time", got)
+	}
+
+	// The same issues give the same metrics on every run
+	for run := 0; run < 2; run++ {
+		registry := registerTestMetrics(t, cfg)
+		issueCollector.processInstance(cfg, []JiraIssue{
+			parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-03-10T10:00:00.000+0000",
+				"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}},
+				"changelog": {"histories": [{"created": "2024-03-14T10:00:00.000+0000", "items": []}]}}`),
+			parseIssue(t, `{"key": "PROJ-2", "fields": {"created": "2023-12-01T10:00:00.000+0000",
+				"status": {"name": "Open"}, "project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`),
+		})
+		if created := gatherMetrics(t, registry, "jira_issues_created_total"); len(created) != 1 || created[0].GetGauge().GetValue() != 1 {
+			t.Errorf("run %d: jira_issues_created_total = %v, want only the issue created in the window", run, created)
+		}
+		oldest := findMetric(gatherMetrics(t, registry, "jira_project_oldest_open_issue_age_seconds"), map[string]string{"project": "PROJ"})
+		if got, want := oldest.GetGauge().GetValue(), (105 * day).Seconds(); got != want {
+			t.Errorf("run %d: jira_project_oldest_open_issue_age_seconds = %v, want %v", run, got, want)
+		}
+		age := findMetric(gatherMetrics(t, registry, "jira_open_issue_age_seconds"), map[string]string{"project": "PROJ"})
+		if got, want := age.GetHistogram().GetSampleSum(), (110 * day).Seconds(); got != want {
+			t.Errorf("run %d: jira_open_issue_age_seconds sum = %v, want %v", run, got, want)
+		}
+		lastChange := findMetric(gatherMetrics(t, registry, "jira_issue_time_since_last_change_seconds"), map[string]string{"project": "PROJ"})
+		if got, want := lastChange.GetHistogram().GetSampleSum(), day.Seconds(); got != want {
+			t.Errorf("run %d: jira_issue_time_since_last_change_seconds sum = %v, want %v", run, got, want)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, injected with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "dev"
+)
+
+// Prometheus metrics of the exporter and the Jira API. They depend on the config, so registerMetrics creates them
+// after the config is loaded. The metrics computed from the issues are held by issueCollector, and the ones
+// of the metric groups by groupCollectors.
+var (
+	issueCollector *Collector
+	// groupCollectors holds the metrics of each metric group by the group name
+	groupCollectors map[string]*Collector
+
+	jiraFetchErrors                         *prometheus.CounterVec
+	jiraAPIRequestDuration                  *prometheus.HistogramVec
+	jiraExporterFetchBytes                  *prometheus.CounterVec
+	jiraIssueChangelogTruncated             *prometheus.CounterVec
+	jiraExporterBuildInfo                   *prometheus.GaugeVec
+	jiraExporterWindowClamped               prometheus.Gauge
+	jiraExporterScrapeErrors                prometheus.Counter
+	jiraExporterRefreshRestarts             prometheus.Counter
+	jiraExporterRefreshIterations           prometheus.Counter
+	jiraExporterRefreshIntervalSkew         prometheus.Gauge
+	jiraExporterProjectErrors               *prometheus.CounterVec
+	jiraExporterIssueLimitHit               *prometheus.GaugeVec
+	jiraExporterPaginationMismatch          *prometheus.CounterVec
+	jiraExporterConfiguredProjects          *prometheus.GaugeVec
+	jiraExporterReturnedProjects            *prometheus.GaugeVec
+	jiraExporterAnalyzePeriod               *prometheus.GaugeVec
+	jiraExporterChangelogProcessingDuration prometheus.Gauge
+)
+
+// registerMetrics creates the metrics with the configured namespace and subsystem and registers them with Prometheus
+func registerMetrics(cfg config) {
+	jiraFetchErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_fetch_errors_total",
+			Help:        "Count of failed Jira API requests by cause.",
+		},
+		instanceLabelNames(cfg, "cause"),
+	)
+	jiraAPIRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_api_request_duration_seconds",
+			Help:        "Latency of Jira API requests until the response headers by endpoint and status class.",
+			Buckets:     prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		instanceLabelNames(cfg, "endpoint", "status"),
+	)
+	jiraExporterFetchBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_fetch_bytes_total",
+			Help:        "Count of bytes of the Jira API responses as received, before the decompression.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraIssueChangelogTruncated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_issue_changelog_truncated_total",
+			Help:        "Count of issue changelogs truncated in the search response and fetched separately.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraExporterBuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_build_info",
+			Help:        "Build information of the exporter, always 1.",
+		},
+		[]string{"version", "commit", "goversion"},
+	)
+	jiraExporterBuildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+	jiraExporterWindowClamped = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_window_clamped",
+			Help:        "1 if an analyze period exceeds MAX_ANALYZE_PERIOD_DAYS and was clamped, 0 otherwise.",
+		},
+	)
+	if cfg.windowClamped {
+		jiraExporterWindowClamped.Set(1)
+	}
+	jiraExporterScrapeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_scrape_errors_total",
+			Help:        "Count of failed data refreshes.",
+		},
+	)
+	jiraExporterRefreshRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_refresh_restarts_total",
+			Help:        "Count of refresh loop restarts after a panic.",
+		},
+	)
+	jiraExporterRefreshIterations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_refresh_iterations_total",
+			Help:        "Count of iterations of the refresh loop.",
+		},
+	)
+	jiraExporterRefreshIntervalSkew = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_refresh_interval_skew_seconds",
+			Help:        "Time between the starts of the last two scheduled refreshes minus DATA_REFRESH_PERIOD.",
+		},
+	)
+	jiraExporterProjectErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_project_errors_total",
+			Help:        "Count of project fetches skipped because the project doesn't exist or isn't visible.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	jiraExporterPaginationMismatch = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_pagination_mismatch_total",
+			Help:        "Count of paginated queries whose number of issues differs from the total reported by Jira.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraExporterConfiguredProjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_configured_projects",
+			Help:        "Number of projects configured in JIRA_PROJECTS.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraExporterReturnedProjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_returned_projects",
+			Help:        "Number of distinct projects of the issues fetched in the last refresh.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraExporterIssueLimitHit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_issue_limit_hit",
+			Help:        "1 if the last fetch stopped at MAX_ISSUES, 0 otherwise.",
+		},
+		instanceLabelNames(cfg),
+	)
+	jiraExporterChangelogProcessingDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_changelog_processing_duration_seconds",
+			Help:        "Time spent computing the status durations from the changelogs of all issues in the last refresh.",
+		},
+	)
+	jiraExporterAnalyzePeriod = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   cfg.metricNamespace,
+			Subsystem:   cfg.metricSubsystem,
+			ConstLabels: cfg.staticLabels,
+			Name:        "jira_exporter_analyze_period_days",
+			Help:        "Effective analyze window of the project in days, after clamping.",
+		},
+		instanceLabelNames(cfg, "project"),
+	)
+	issueCollector = NewCollector(ungroupedConfig(cfg))
+	groupCollectors = make(map[string]*Collector, len(cfg.metricGroups))
+	for _, group := range cfg.metricGroups {
+		groupCollectors[group.name] = NewCollector(groupConfig(cfg, group))
+	}
+
+	exporterMetrics := []namedCollector{
+		{"jira_fetch_errors_total", jiraFetchErrors},
+		{"jira_api_request_duration_seconds", jiraAPIRequestDuration},
+		{"jira_exporter_fetch_bytes_total", jiraExporterFetchBytes},
+		{"jira_issue_changelog_truncated_total", jiraIssueChangelogTruncated},
+		{"jira_exporter_build_info", jiraExporterBuildInfo},
+		{"jira_exporter_window_clamped", jiraExporterWindowClamped},
+		{"jira_exporter_scrape_errors_total", jiraExporterScrapeErrors},
+		{"jira_exporter_refresh_restarts_total", jiraExporterRefreshRestarts},
+		{"jira_exporter_refresh_iterations_total", jiraExporterRefreshIterations},
+		{"jira_exporter_refresh_interval_skew_seconds", jiraExporterRefreshIntervalSkew},
+		{"jira_exporter_project_errors_total", jiraExporterProjectErrors},
+		{"jira_exporter_issue_limit_hit", jiraExporterIssueLimitHit},
+		{"jira_exporter_pagination_mismatch_total", jiraExporterPaginationMismatch},
+		{"jira_exporter_configured_projects", jiraExporterConfiguredProjects},
+		{"jira_exporter_returned_projects", jiraExporterReturnedProjects},
+		{"jira_exporter_changelog_processing_duration_seconds", jiraExporterChangelogProcessingDuration},
+		{"jira_exporter_analyze_period_days", jiraExporterAnalyzePeriod},
+	}
+	failOnError(checkMetricNames(cfg, append(exporterMetrics, issueCollector.namedCollectors()...)))
+	failOnError(checkMetricGroups(cfg, issueCollector.namedCollectors()))
+
+	// Register metrics with Prometheus
+	prometheus.MustRegister(issueCollector)
+	for _, collector := range groupCollectors {
+		prometheus.MustRegister(collector)
+	}
+	for _, metric := range enabledCollectors(cfg, exporterMetrics) {
+		prometheus.MustRegister(metric)
+	}
+}
+
+// setProjectCounts sets the numbers of the configured projects of the instance and of the projects of its fetched
+// issues. It warns about the configured projects without issues, e.g. misspelled in JIRA_PROJECTS.
+func setProjectCounts(cfg config, issues []JiraIssue) {
+	returned := make(map[string]bool)
+	for _, issue := range issues {
+		returned[strings.ToUpper(issue.Fields.Project.Key)] = true
+	}
+	configured := 0
+	for _, window := range cfg.projects {
+		for _, project := range window.projects {
+			configured++
+			if !returned[strings.ToUpper(project)] {
+				fmt.Printf("Warning: no issues of the configured project %s on %s in the analyze window, check JIRA_PROJECTS\n", project, cfg.jiraURL)
+			}
+		}
+	}
+	jiraExporterConfiguredProjects.WithLabelValues(instanceLabelValues(cfg)...).Set(float64(configured))
+	jiraExporterReturnedProjects.WithLabelValues(instanceLabelValues(cfg)...).Set(float64(len(returned)))
+}
+
+// namedCollector is a metric collector with the metric name without the namespace and the subsystem
+type namedCollector struct {
+	name      string
+	collector prometheus.Collector
+}
+
+// enabledCollectors returns the collectors of the metrics enabled by ENABLED_METRICS and DISABLED_METRICS
+func enabledCollectors(cfg config, named []namedCollector) []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0, len(named))
+	for _, metric := range named {
+		if isMetricEnabled(cfg, metric.name) {
+			collectors = append(collectors, metric.collector)
+		}
+	}
+	return collectors
+}
+
+// checkMetricNames checks that ENABLED_METRICS and DISABLED_METRICS list only the known metrics,
+// as a typo in ENABLED_METRICS would silently drop the metric
+func checkMetricNames(cfg config, named []namedCollector) error {
+	known := []string{"jira_issue_time_in_status_summary"}
+	for _, metric := range named {
+		known = append(known, metric.name)
+	}
+	for env, names := range map[string][]string{"ENABLED_METRICS": cfg.enabledMetrics, "DISABLED_METRICS": cfg.disabledMetrics} {
+		for _, name := range names {
+			if !slices.Contains(known, name) {
+				return fmt.Errorf("unknown metric %q in %s", name, env)
+			}
+		}
+	}
+	return nil
+}
+
+// isMetricEnabled checks the metric name against the enabled and disabled lists. Disabling takes precedence,
+// and an empty enabled list enables all metrics.
+func isMetricEnabled(cfg config, name string) bool {
+	if slices.Contains(cfg.disabledMetrics, name) {
+		return false
+	}
+	return len(cfg.enabledMetrics) == 0 || slices.Contains(cfg.enabledMetrics, name)
+}
+
+// setAnalyzePeriods sets the analyze window of each project at now. The windows starting at a function like
+// startOfMonth grow until the next start.
+func setAnalyzePeriods(cfg config, now time.Time) {
+	for _, instance := range cfg.instances {
+		for _, window := range instance.projects {
+			for _, project := range window.projects {
+				days := now.Sub(windowStart(instance, project, now)).Hours() / 24
+				jiraExporterAnalyzePeriod.WithLabelValues(instanceLabelValues(instance, project)...).Set(days)
+			}
+		}
+	}
+}
+
+// issueTypeHistogramVec is a histogram vector with the buckets overridden for some values of the issueType label.
+// The vectors share the descriptor, so it's a single collector.
+type issueTypeHistogramVec struct {
+	defaultVec  *prometheus.HistogramVec
+	byIssueType map[string]*prometheus.HistogramVec
+}
+
+// With returns the histogram with the buckets of the issue type in the labels
+func (v *issueTypeHistogramVec) With(labels prometheus.Labels) prometheus.Observer {
+	if vec, ok := v.byIssueType[labels["issueType"]]; ok {
+		return vec.With(labels)
+	}
+	return v.defaultVec.With(labels)
+}
+
+// Reset deletes the histograms of all issue types
+func (v *issueTypeHistogramVec) Reset() {
+	v.defaultVec.Reset()
+	for _, vec := range v.byIssueType {
+		vec.Reset()
+	}
+}
+
+// Describe implements prometheus.Collector
+func (v *issueTypeHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	v.defaultVec.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (v *issueTypeHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	v.defaultVec.Collect(ch)
+	for _, vec := range v.byIssueType {
+		vec.Collect(ch)
+	}
+}
+
+// metricLabelNames is the label names of the metrics, including the ones of the histograms and the summaries
+var metricLabelNames = []string{
+	"instance", "project", "priority", "status", "statusCategory", "assignee", "reporter", "issueType", "parentKey",
+	"sprint", "sprintState", "epic", "label", "kind", "bucket", "field", "from_category", "to_category", "cause", "endpoint",
+	"version", "commit", "goversion", "le", "quantile",
+}
+
+// labelNamePattern matches the valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseStaticLabels parses the comma-separated key=value pairs of the constant labels, e.g. "env=prod,region=eu".
+// The names can't be the ones of the metric labels, as a metric can't have the same label twice.
+func parseStaticLabels(spec string) (prometheus.Labels, error) {
+	labels := make(prometheus.Labels)
+	for _, pair := range parseList(spec) {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid static label %q, must be name=value", pair)
+		}
+		if slices.Contains(metricLabelNames, name) {
+			return nil, fmt.Errorf("static label %q conflicts with a metric label", name)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// parseCountLabels parses the comma-separated subset of jira_issue_count labels. An empty spec selects
+// all labels available with the config, except parentKey, which has to be selected explicitly.
+func parseCountLabels(spec string, cfg config) ([]string, error) {
+	available := []string{"project", "priority", "status", "statusCategory", "assignee", "issueType"}
+	if cfg.sprintField != "" {
+		available = append(available, "sprint", "sprintState")
+	}
+	if spec == "" {
+		return available, nil
+	}
+	// A parent per series would multiply the cardinality
+	available = append(available, "parentKey")
+	labels := make([]string, 0)
+	for _, label := range strings.Split(spec, ",") {
+		label = strings.TrimSpace(label)
+		if !slices.Contains(available, label) {
+			return nil, fmt.Errorf("unknown count label %q, available: %s", label, strings.Join(available, ","))
+		}
+		if !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
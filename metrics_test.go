@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountLabels(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.countLabels, err = parseCountLabels("project, status,project", cfg); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	first := parseIssue(t, testIssueJSON("PROJ-1"))
+	first.Fields.Assignee.EmailAddress = "a@example.com"
+	second := parseIssue(t, testIssueJSON("PROJ-2"))
+	second.Fields.Assignee.EmailAddress = "b@example.com"
+	issueCollector.processIssue(cfg, first)
+	issueCollector.processIssue(cfg, second)
+
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	if len(counts) != 1 {
+		t.Fatalf("jira_issue_count has %d series, want the assignees aggregated into 1", len(counts))
+	}
+	names := make([]string, 0)
+	for _, pair := range counts[0].GetLabel() {
+		names = append(names, pair.GetName())
+	}
+	if !slices.Equal(names, []string{"project", "status"}) {
+		t.Errorf("labels = %q, want [project status]", names)
+	}
+	if value := counts[0].GetGauge().GetValue(); value != 2 {
+		t.Errorf("jira_issue_count = %v, want 2", value)
+	}
+}
+
+func TestParseCountLabels(t *testing.T) {
+	cfg := config{}
+	labels, err := parseCountLabels("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"project", "priority", "status", "statusCategory", "assignee", "issueType"}; !slices.Equal(labels, want) {
+		t.Errorf("default labels = %q, want %q", labels, want)
+	}
+	for _, spec := range []string{"project,unknown", "sprint"} {
+		if _, err := parseCountLabels(spec, cfg); err == nil {
+			t.Errorf("parseCountLabels(%q) succeeded, want an error", spec)
+		}
+	}
+	cfg.sprintField = "customfield_10020"
+	if _, err := parseCountLabels("sprint", cfg); err != nil {
+		t.Errorf("parseCountLabels(\"sprint\") with the sprint field: %v", err)
+	}
+}
+
+func TestMetricNamespace(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.metricNamespace = "jira_exporter"
+	cfg.metricSubsystem = "cloud"
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueJSON("PROJ-1")))
+
+	for _, name := range []string{
+		"jira_exporter_cloud_jira_issue_count",
+		"jira_exporter_cloud_jira_exporter_build_info",
+		"jira_exporter_cloud_jira_exporter_scrape_errors_total",
+	} {
+		if metrics := gatherMetrics(t, registry, name); len(metrics) == 0 {
+			t.Errorf("%s is not registered", name)
+		}
+	}
+	if metrics := gatherMetrics(t, registry, "jira_issue_count"); len(metrics) != 0 {
+		t.Error("jira_issue_count is registered without the prefix")
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	registry := registerTestMetrics(t, testConfig(t))
+	info := gatherMetrics(t, registry, "jira_exporter_build_info")
+	want := map[string]string{"version": "dev", "commit": "dev", "goversion": runtime.Version()}
+	if len(info) != 1 || findMetric(info, want) == nil || info[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_exporter_build_info = %v, want 1 with %v", info, want)
+	}
+}
+
+func TestAnalyzePeriodDays(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.projects, err = parseProjects("HOURS:720h,WEEKS:2w,LONG:400,MONTH:startOfMonth,PROJ", cfg.analyzePeriod); err != nil {
+		t.Fatal(err)
+	}
+	clampPeriods(cfg.projects, 365*day)
+	cfg.instances = []config{cfg}
+	registry := registerTestMetrics(t, cfg)
+
+	setAnalyzePeriods(cfg, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC))
+	periods := gatherMetrics(t, registry, "jira_exporter_analyze_period_days")
+	for project, want := range map[string]float64{"HOURS": 30, "WEEKS": 14, "LONG": 365, "MONTH": 14.5, "PROJ": 90} {
+		if metric := findMetric(periods, map[string]string{"project": project}); metric.GetGauge().GetValue() != want {
+			t.Errorf("jira_exporter_analyze_period_days{project=%q} = %v, want %v", project, metric.GetGauge().GetValue(), want)
+		}
+	}
+}
+
+func TestParentKeyLabel(t *testing.T) {
+	cfg := testConfig(t)
+	if slices.Contains(cfg.countLabels, "parentKey") || strings.Contains(searchFields(cfg), "parent") {
+		t.Errorf("parentKey is selected by default: labels %q, fields %q", cfg.countLabels, searchFields(cfg))
+	}
+	var err error
+	if cfg.countLabels, err = parseCountLabels("project,issueType,parentKey", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if fields := strings.Split(searchFields(cfg), ","); !slices.Contains(fields, "parent") {
+		t.Errorf("fields = %q, want the parent requested for the parentKey label", fields)
+	}
+	registry := registerTestMetrics(t, cfg)
+	for i, issue := range []struct{ issueType, parent string }{
+		{"Sub-task", `, "parent": {"id": "10001", "key": "PROJ-1", "fields": {"summary": "Story"}}`},
+		{"Sub-task", `, "parent": {"id": "10001", "key": "PROJ-1"}`},
+		{"Sub-task", `, "parent": {"id": "10002", "key": "PROJ-2"}`},
+		{"Story", ``},
+		{"Story", `, "parent": null`},
+	} {
+		issueCollector.processIssue(cfg, parseIssue(t, fmt.Sprintf(`{"key": "PROJ-%d", "fields": {
+			"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+			"project": {"key": "PROJ"}, "issuetype": {"name": %q}%s}}`, i+10, issue.issueType, issue.parent)))
+	}
+
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	want := map[[2]string]float64{{"Sub-task", "PROJ-1"}: 2, {"Sub-task", "PROJ-2"}: 1, {"Story", "none"}: 2}
+	if len(counts) != len(want) {
+		t.Errorf("jira_issue_count has %d series, want %d", len(counts), len(want))
+	}
+	for key, value := range want {
+		if metric := findMetric(counts, map[string]string{"issueType": key[0], "parentKey": key[1]}); metric.GetGauge().GetValue() != value {
+			t.Errorf("jira_issue_count{issueType=%q,parentKey=%q} = %v, want %v", key[0], key[1], metric.GetGauge().GetValue(), value)
+		}
+	}
+}
+
+func TestEnabledMetrics(t *testing.T) {
+	exposed := func(cfg config) []string {
+		t.Helper()
+		registerTestMetrics(t, cfg)
+		issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-1")))
+		jiraFetchErrors.WithLabelValues("auth").Inc()
+		recorder := httptest.NewRecorder()
+		metricsHandler(cfg).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		var names []string
+		for _, line := range strings.Split(recorder.Body.String(), "\n") {
+			if name, ok := strings.CutPrefix(line, "# TYPE "); ok && strings.HasPrefix(name, "jira_") {
+				names = append(names, strings.Fields(name)[0])
+			}
+		}
+		return names
+	}
+
+	cfg := testConfig(t)
+	all := exposed(cfg)
+	for _, name := range []string{"jira_issue_count", "jira_issue_time_in_status", "jira_fetch_errors_total", "jira_exporter_build_info"} {
+		if !slices.Contains(all, name) {
+			t.Errorf("%s is not exposed by default", name)
+		}
+	}
+
+	cfg.disabledMetrics = []string{"jira_issue_time_in_status", "jira_fetch_errors_total"}
+	names := exposed(cfg)
+	if slices.Contains(names, "jira_issue_time_in_status") || slices.Contains(names, "jira_fetch_errors_total") {
+		t.Errorf("the disabled metrics are exposed: %v", names)
+	}
+	if len(names) != len(all)-2 {
+		t.Errorf("exposed %d metrics, want all %d but the 2 disabled ones", len(names), len(all))
+	}
+
+	cfg.enabledMetrics = []string{"jira_issue_count", "jira_issue_time_in_status", "jira_exporter_build_info"}
+	if names := exposed(cfg); !slices.Equal(names, []string{"jira_exporter_build_info", "jira_issue_count"}) {
+		t.Errorf("exposed %v, want the enabled metrics but the disabled one", names)
+	}
+
+	cfg.enabledMetrics = []string{"jira_issue_cuont"}
+	if err := checkMetricNames(cfg, NewCollector(cfg).namedCollectors()); err == nil {
+		t.Error("checkMetricNames() accepted an unknown metric")
+	}
+}
+
+func TestStaticLabels(t *testing.T) {
+	cfg := testConfig(t)
+	var err error
+	if cfg.staticLabels, err = parseStaticLabels("env=prod, region = eu"); err != nil {
+		t.Fatal(err)
+	}
+	cfg.enableStatusSummary = true
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueWithTransitionJSON("PROJ-1")))
+	jiraFetchErrors.WithLabelValues("auth").Inc()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) < 10 {
+		t.Fatalf("gathered %d metric families, want all the metrics", len(families))
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["env"] != "prod" || labels["region"] != "eu" {
+				t.Errorf("%s has the labels %v, want env=prod and region=eu", family.GetName(), labels)
+			}
+		}
+	}
+
+	for _, spec := range []string{"env", "=prod", "env-name=prod", "__env=prod", "project=x", "le=1"} {
+		if _, err := parseStaticLabels(spec); err == nil {
+			t.Errorf("parseStaticLabels(%q) succeeded, want an error", spec)
+		}
+	}
+}
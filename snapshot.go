@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot is the issues of the last successful refresh persisted to populate the metrics at startup
+type snapshot struct {
+	SavedAt   time.Time          `json:"savedAt"`
+	Instances []snapshotInstance `json:"instances"`
+}
+
+// snapshotInstance is the issues fetched from an instance. The name is empty for the single unnamed instance.
+type snapshotInstance struct {
+	Instance string      `json:"instance"`
+	Issues   []JiraIssue `json:"issues"`
+}
+
+// saveSnapshot writes the issues fetched from each instance to the file. The file is replaced atomically,
+// so a crash while writing leaves the previous snapshot.
+func saveSnapshot(path string, cfg config, fetched [][]JiraIssue, savedAt time.Time) error {
+	s := snapshot{SavedAt: savedAt, Instances: make([]snapshotInstance, 0, len(fetched))}
+	for i, issues := range fetched {
+		s.Instances = append(s.Instances, snapshotInstance{Instance: cfg.instances[i].instance, Issues: issues})
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreSnapshot populates the metrics from the snapshot file and marks the refresh as done at the time
+// the snapshot was saved, so the readiness still reflects its age. A missing file is not an error.
+// The issues of the instances that are no longer configured are skipped.
+func restoreSnapshot(path string, cfg config) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("corrupt snapshot %s: %w", path, err)
+	}
+	issueCollector.Reset()
+	total := 0
+	for _, saved := range s.Instances {
+		instance, ok := findInstance(cfg, saved.Instance)
+		if !ok || instance.instance != saved.Instance {
+			continue
+		}
+		issueCollector.processInstance(instance, saved.Issues)
+		setProjectCounts(instance, saved.Issues)
+		total += len(saved.Issues)
+	}
+	lastSuccessfulRefresh.Store(s.SavedAt.UnixNano())
+	fmt.Printf("Restored %d issues from the snapshot saved at %s\n", total, s.SavedAt)
+	return nil
+}
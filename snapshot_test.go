@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	previous := lastSuccessfulRefresh.Load()
+	t.Cleanup(func() { lastSuccessfulRefresh.Store(previous) })
+	cfg := testConfig(t)
+	cfg.storyPointsField = "customfield_10016"
+	cfg.instances = []config{cfg}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	issue := parseIssue(t, `{"key": "PROJ-1", "fields": {"created": "2024-01-01T10:00:00.000+0000",
+		"status": {"name": "Done", "statusCategory": {"key": "done", "name": "Done"}},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}, "customfield_10016": 5},
+		"changelog": {"histories": [{"created": "2024-01-02T10:00:00.000+0000",
+			"items": [{"field": "status", "fromString": "Open", "toString": "Done"}]}]}}`)
+	savedAt := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	if err := saveSnapshot(path, cfg, [][]JiraIssue{{issue}}, savedAt); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+
+	if err := restoreSnapshot(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 1 || counts[0].GetGauge().GetValue() != 1 {
+		t.Errorf("jira_issue_count = %v, want the restored issue", counts)
+	}
+	if points := gatherMetrics(t, registry, "jira_issue_story_points"); len(points) != 1 || points[0].GetGauge().GetValue() != 5 {
+		t.Errorf("jira_issue_story_points = %v, want 5 from the restored custom field", points)
+	}
+	histogram := findMetric(gatherMetrics(t, registry, "jira_issue_time_in_status"), map[string]string{"project": "PROJ"}).GetHistogram()
+	if histogram.GetSampleSum() != day.Seconds() {
+		t.Errorf("jira_issue_time_in_status sum = %v, want 1 day from the restored changelog", histogram.GetSampleSum())
+	}
+	if got := lastSuccessfulRefresh.Load(); got != savedAt.UnixNano() {
+		t.Errorf("last successful refresh = %s, want the snapshot time %s", time.Unix(0, got), savedAt)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files are left: %q", matches)
+	}
+}
+
+func TestRestoreCorruptSnapshot(t *testing.T) {
+	previous := lastSuccessfulRefresh.Load()
+	t.Cleanup(func() { lastSuccessfulRefresh.Store(previous) })
+	lastSuccessfulRefresh.Store(0)
+	cfg := testConfig(t)
+	cfg.instances = []config{cfg}
+	registry := registerTestMetrics(t, cfg)
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte(`{"savedAt": "2024-01-03T10:00:00Z", "instances": [{"issues": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreSnapshot(corrupt, cfg); err == nil {
+		t.Error("restoreSnapshot() of a corrupt file succeeded, want an error")
+	}
+	if err := restoreSnapshot(filepath.Join(dir, "missing.json"), cfg); err != nil {
+		t.Errorf("restoreSnapshot() of a missing file: %v, want no error", err)
+	}
+	if counts := gatherMetrics(t, registry, "jira_issue_count"); len(counts) != 0 {
+		t.Errorf("jira_issue_count = %v, want no metrics until the first refresh", counts)
+	}
+	if lastSuccessfulRefresh.Load() != 0 {
+		t.Error("the failed restore marked the refresh as done")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// sprint is an entry of the sprint custom field
+type sprint struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	StartDate string `json:"startDate"`
+}
+
+// parseSprints decodes the sprint custom field. Depending on the Jira version, its entries are either
+// objects or strings like "com.atlassian.greenhopper.service.sprint.Sprint@1f[id=1,state=ACTIVE,name=Sprint 1,...]".
+func parseSprints(raw json.RawMessage) ([]sprint, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+	sprints := make([]sprint, 0, len(entries))
+	for _, entry := range entries {
+		var serialized string
+		if err := json.Unmarshal(entry, &serialized); err == nil {
+			sprints = append(sprints, parseSerializedSprint(serialized))
+			continue
+		}
+		var s sprint
+		if err := json.Unmarshal(entry, &s); err != nil {
+			return nil, err
+		}
+		s.State = strings.ToLower(s.State)
+		sprints = append(sprints, s)
+	}
+	return sprints, nil
+}
+
+// parseSerializedSprint parses the legacy string form of a sprint. Attribute values may contain commas,
+// so a part without the "name=" prefix is appended to the previous attribute.
+func parseSerializedSprint(serialized string) sprint {
+	start := strings.Index(serialized, "[")
+	end := strings.LastIndex(serialized, "]")
+	if start < 0 || end < start {
+		return sprint{}
+	}
+	attrs := make(map[string]string)
+	key := ""
+	for _, part := range strings.Split(serialized[start+1:end], ",") {
+		name, value, found := strings.Cut(part, "=")
+		if found && isAttributeName(name) {
+			key = name
+			attrs[key] = value
+			continue
+		}
+		if key != "" {
+			attrs[key] += "," + part
+		}
+	}
+	for name, value := range attrs {
+		if value == "<null>" {
+			attrs[name] = ""
+		}
+	}
+	id, _ := strconv.Atoi(attrs["id"])
+	return sprint{
+		ID:        id,
+		Name:      attrs["name"],
+		State:     strings.ToLower(attrs["state"]),
+		StartDate: attrs["startDate"],
+	}
+}
+
+func isAttributeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// currentSprint picks the active sprint, otherwise the one started most recently. Sprints that haven't
+// started yet count as the most recent.
+func currentSprint(sprints []sprint) (sprint, bool) {
+	if len(sprints) == 0 {
+		return sprint{}, false
+	}
+	current := sprints[0]
+	for _, s := range sprints[1:] {
+		if rankSprint(s) >= rankSprint(current) {
+			current = s
+		}
+	}
+	return current, true
+}
+
+func rankSprint(s sprint) string {
+	switch {
+	case s.State == "active":
+		return "2"
+	case s.StartDate == "":
+		return "1"
+	default:
+		return "0" + s.StartDate
+	}
+}
+
+// issueSprint returns the issue's current sprint. The second result is false if the issue has no sprint.
+func issueSprint(issue JiraIssue, field string) (sprint, bool) {
+	raw, ok := issue.CustomFields[field]
+	if !ok {
+		return sprint{}, false
+	}
+	sprints, err := parseSprints(raw)
+	if err != nil {
+		return sprint{}, false
+	}
+	return currentSprint(sprints)
+}
+
+// sprintName returns the name of the issue's current sprint, or "none"
+func sprintName(issue JiraIssue, field string) string {
+	s, ok := issueSprint(issue, field)
+	if !ok || s.Name == "" {
+		return "none"
+	}
+	return s.Name
+}
+
+// sprintState returns the lower-cased state of the issue's current sprint: active, closed or future, or "none"
+func sprintState(issue JiraIssue, field string) string {
+	s, ok := issueSprint(issue, field)
+	if !ok || s.State == "" {
+		return "none"
+	}
+	return s.State
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSerializedSprint(t *testing.T) {
+	got := parseSerializedSprint("com.atlassian.greenhopper.service.sprint.Sprint@1f2e3d[id=42,rapidViewId=7," +
+		"state=CLOSED,name=Sprint 1, part 2,startDate=2024-01-01T10:00:00.000Z,endDate=<null>,sequence=42,goal=a,b=c]")
+	want := sprint{ID: 42, Name: "Sprint 1, part 2", State: "closed", StartDate: "2024-01-01T10:00:00.000Z"}
+	if got != want {
+		t.Errorf("parseSerializedSprint() = %+v, want %+v", got, want)
+	}
+	if got := parseSerializedSprint("garbage"); got != (sprint{}) {
+		t.Errorf("parseSerializedSprint() of garbage = %+v, want empty", got)
+	}
+}
+
+func TestSprintName(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+		state string
+	}{
+		{"absent", ``, "none", "none"},
+		{"null", `null`, "none", "none"},
+		{"empty", `[]`, "none", "none"},
+		{"not an array", `"Sprint 1"`, "none", "none"},
+		{
+			"legacy strings with the active one first",
+			`["com.atlassian.greenhopper.service.sprint.Sprint@1[id=2,state=ACTIVE,name=Sprint 2,startDate=2024-01-15T10:00:00.000Z]",
+			  "com.atlassian.greenhopper.service.sprint.Sprint@2[id=1,state=CLOSED,name=Sprint 1,startDate=2024-01-01T10:00:00.000Z]"]`,
+			"Sprint 2",
+			"active",
+		},
+		{
+			"legacy strings of a future sprint",
+			`["com.atlassian.greenhopper.service.sprint.Sprint@2[id=1,state=CLOSED,name=Sprint 1,startDate=2024-01-01T10:00:00.000Z]",
+			  "com.atlassian.greenhopper.service.sprint.Sprint@1[id=2,state=FUTURE,name=Sprint 2,startDate=<null>]"]`,
+			"Sprint 2",
+			"future",
+		},
+		{
+			"objects without an active one",
+			`[{"id": 1, "name": "Sprint 1", "state": "closed", "startDate": "2024-01-01T10:00:00.000Z"},
+			  {"id": 3, "name": "Sprint 3", "state": "closed", "startDate": "2024-02-01T10:00:00.000Z"},
+			  {"id": 2, "name": "Sprint 2", "state": "closed", "startDate": "2024-01-15T10:00:00.000Z"}]`,
+			"Sprint 3",
+			"closed",
+		},
+		{
+			"future sprint",
+			`[{"id": 1, "name": "Sprint 1", "state": "closed", "startDate": "2024-01-01T10:00:00.000Z"},
+			  {"id": 2, "name": "Sprint 2", "state": "FUTURE"}]`,
+			"Sprint 2",
+			"future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := JiraIssue{}
+			if tt.field != "" {
+				issue.CustomFields = map[string]json.RawMessage{"customfield_10020": json.RawMessage(tt.field)}
+			}
+			if got := sprintName(issue, "customfield_10020"); got != tt.want {
+				t.Errorf("sprintName() = %q, want %q", got, tt.want)
+			}
+			if got := sprintState(issue, "customfield_10020"); got != tt.state {
+				t.Errorf("sprintState() = %q, want %q", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestSprintLabel(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.sprintField = "customfield_10020"
+	var err error
+	if cfg.countLabels, err = parseCountLabels("project,sprint,sprintState", cfg); err != nil {
+		t.Fatal(err)
+	}
+	registry := registerTestMetrics(t, cfg)
+	issueCollector.processIssue(cfg, parseIssue(t, `{"key": "PROJ-1", "fields": {
+		"customfield_10020": [{"id": 1, "name": "Sprint 1", "state": "active"}],
+		"created": "2024-01-01T10:00:00.000+0000", "status": {"name": "Open"},
+		"project": {"key": "PROJ"}, "issuetype": {"name": "Task"}}}`))
+	issueCollector.processIssue(cfg, parseIssue(t, testIssueJSON("PROJ-2")))
+
+	counts := gatherMetrics(t, registry, "jira_issue_count")
+	for sprint, state := range map[string]string{"Sprint 1": "active", "none": "none"} {
+		if metric := findMetric(counts, map[string]string{"sprint": sprint, "sprintState": state}); metric.GetGauge().GetValue() != 1 {
+			t.Errorf("jira_issue_count{sprint=%q,sprintState=%q} = %v, want 1", sprint, state, metric.GetGauge().GetValue())
+		}
+	}
+}